@@ -1,13 +1,36 @@
 package sh
 
 import (
+	"errors"
 	"time"
 )
 
 //Apply mutates the game state by applying the given event.
 func (g Game) Apply(e Event) (Game, Event, error) {
+	return g.apply(e, time.Now())
+}
+
+//Replay applies an event that was previously applied and persisted. The event
+//keeps the id and moment it was originally assigned.
+func (g Game) Replay(e Event) (Game, error) {
+	if e.GetID() != g.EventID+1 {
+		return g, errors.New("Event log is out of order")
+	}
+	g, _, err := g.apply(e, e.GetMoment())
+	return g, err
+}
+
+func (g Game) apply(e Event, now time.Time) (Game, Event, error) {
 	//Increment the event counter
 	g.EventID = g.EventID + 1
+	//Player events update the players and votes in place, so give the new state
+	//its own copies rather than sharing them with the old state
+	switch e.(type) {
+	case PlayerVoteEvent:
+		g.Round.Votes = append(g.Round.Votes[:0:0], g.Round.Votes...)
+	case PlayerEvent, PlayerPlayerEvent, MessageEvent, ReactEvent, GuessEvent:
+		g.Players = append(g.Players[:0:0], g.Players...)
+	}
 
 	//Assign the event id to the event
 	switch e.GetType() {
@@ -15,13 +38,13 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypePlayerJoin:
 		ne := e.(PlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		g.Players = append(g.Players, ne.Player)
 	case TypePlayerReady:
 		ne := e.(PlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if p.ID == ne.Player.ID {
@@ -32,7 +55,7 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypePlayerAcknowledge:
 		ne := e.(PlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		//Switch the given users ack attribute to true
 		for i, p := range g.Players {
@@ -44,26 +67,26 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypePlayerNominate:
 		ne := e.(PlayerPlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		//Add the chancelor to the round object
 		g.Round.ChancellorID = ne.OtherPlayerID
 	case TypePlayerVote:
 		ne := e.(PlayerVoteEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		//Add the given vote to the rounds vote array
 		g.Round.Votes = append(g.Round.Votes, Vote{ne.PlayerID, ne.Vote})
 	case TypePlayerLegislate:
 		ne := e.(PlayerLegislateEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 	case TypePlayerInvestigate:
 		ne := e.(PlayerPlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if p.ID == ne.OtherPlayerID {
@@ -73,14 +96,14 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypePlayerSpecialElection:
 		ne := e.(PlayerPlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		g.SpecialElectionPresidentID = ne.OtherPlayerID
 		g.SpecialElectionRoundID = g.Round.ID + 1
 	case TypePlayerExecute:
 		ne := e.(PlayerPlayerEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if p.ID == ne.OtherPlayerID {
@@ -90,7 +113,7 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypePlayerMessage:
 		ne := e.(MessageEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if ne.PlayerID == p.ID {
@@ -103,7 +126,8 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypeAssertParty:
 		ne := e.(AssertEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
+		ne.Verified = g.verifyClaim(ne, now)
 		e = ne
 	//REACT EVENTS
 	case TypeReactPlayer:
@@ -113,7 +137,7 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypeReactStatus:
 		ne := e.(ReactEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if ne.PlayerID == p.ID {
@@ -124,7 +148,7 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypeGuess:
 		ne := e.(GuessEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		for i, p := range g.Players {
 			if ne.PlayerID == p.ID {
@@ -135,48 +159,58 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 	case TypeRequestAcknowledge:
 		ne := e.(RequestEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 	case TypeRequestVote:
 		ne := e.(RequestEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		//Set the round state to voting
 		g.Round.State = RoundStateVoting
 	case TypeRequestNominate:
 		ne := e.(RequestEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		g.Round.State = RoundStateNominating
 	case TypeRequestLegislate:
 		ne := e.(RequestEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		g.Round.State = RoundStateLegislating
 	case TypeRequestExecutiveAction:
 		ne := e.(RequestEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		g.Round.State = RoundStateExecutiveAction
 	//GAME EVENTS
 	case TypeGameVoteResults:
 		ne := e.(VoteResultEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 	case TypeGameInformation:
 		ne := e.(InformationEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
+		e = ne
+	case TypeGameFinished:
+		ne := e.(FinishedEvent)
+		ne.ID = g.EventID
+		ne.Moment = now
+		e = ne
+	case TypeGameTimeout:
+		ne := e.(TimeoutEvent)
+		ne.ID = g.EventID
+		ne.Moment = now
 		e = ne
 	case TypeGameUpdate:
 		ne := e.(GameEvent)
 		ne.ID = g.EventID
-		ne.Moment = time.Now()
+		ne.Moment = now
 		e = ne
 		//The event data, set the discard and draw pile accordingly
 		if ne.Game.ID == "-" {
@@ -189,6 +223,19 @@ func (g Game) Apply(e Event) (Game, Event, error) {
 		} else if ne.Game.Secret != "" {
 			g.Secret = ne.Game.Secret
 		}
+		if len(ne.Game.Keys) > 0 {
+			g.Keys = ne.Game.Keys
+		}
+		if ne.Game.TokenTTL != 0 {
+			g.TokenTTL = ne.Game.TokenTTL
+		}
+		if ne.Game.HonestClaims {
+			g.HonestClaims = true
+		}
+		if ne.Game.Seed != 0 {
+			g.Seed = ne.Game.Seed
+		}
+		g.Timeouts = g.Timeouts.merge(ne.Game.Timeouts)
 		if ne.Game.State == "-" {
 			g.State = ""
 		} else if ne.Game.State != "" {
@@ -0,0 +1,267 @@
+//Package auth registers players and hands out signed session tokens. A token
+//carries the players id, so logging in again, from anywhere, resumes the same
+//player in every game they have joined. The sessions are kept in memory, and the
+//accounts are too unless they are persisted to an AccountStore.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	sh "github.com/murphysean/secrethitler"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//header is the encoded jwt header of every session token
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+//Claims are the contents of a session token
+type Claims struct {
+	SessionID string `json:"jti"`
+	PlayerID  string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type account struct {
+	PlayerID string `json:"playerId"`
+	Hash     []byte `json:"hash"`
+}
+
+//AccountStore persists the accounts, so every player keeps their id, and with it
+//their games, across restarts. The records are opaque to the store.
+type AccountStore interface {
+	//SaveAccount stores the record of the account with the name
+	SaveAccount(name string, record []byte) error
+	//LoadAccounts returns the record of every account by its name
+	LoadAccounts() (map[string][]byte, error)
+}
+
+type session struct {
+	playerID string
+	expires  time.Time
+}
+
+//Authenticator keeps the player accounts and their sessions
+type Authenticator struct {
+	//TTL is how long a session token is good for
+	TTL time.Duration
+	//Store, if set, persists every account that registers
+	Store AccountStore
+
+	key []byte
+	//dummy is checked against when the account doesn't exist, so a login takes
+	//as long whether or not the name is registered
+	dummy    []byte
+	m        sync.Mutex
+	accounts map[string]account
+	sessions map[string]session
+}
+
+//New returns an authenticator that signs its tokens with the key
+func New(key []byte, ttl time.Duration) *Authenticator {
+	a := new(Authenticator)
+	a.TTL = ttl
+	a.key = key
+	a.dummy, _ = bcrypt.GenerateFromPassword([]byte(genID()), bcrypt.DefaultCost)
+	a.accounts = make(map[string]account)
+	a.sessions = make(map[string]session)
+	return a
+}
+
+func genID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+//Register creates an account and logs it in. Each account is given its own
+//player id, which never changes.
+func (a *Authenticator) Register(name, password string) (string, error) {
+	if name == "" || password == "" {
+		return "", errors.New("Name and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	if _, ok := a.accounts[name]; ok {
+		return "", errors.New("Name is taken")
+	}
+	acc := account{PlayerID: genID(), Hash: hash}
+	if a.Store != nil {
+		b, err := json.Marshal(acc)
+		if err != nil {
+			return "", err
+		}
+		if err = a.Store.SaveAccount(name, b); err != nil {
+			return "", err
+		}
+	}
+	a.accounts[name] = acc
+	return a.issue(acc.PlayerID, time.Now())
+}
+
+//LoadAccounts brings back the accounts persisted to the Store, returning how
+//many there are
+func (a *Authenticator) LoadAccounts() (int, error) {
+	if a.Store == nil {
+		return 0, errors.New("Authenticator has no store")
+	}
+	records, err := a.Store.LoadAccounts()
+	if err != nil {
+		return 0, err
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	for name, b := range records {
+		acc := account{}
+		if err = json.Unmarshal(b, &acc); err != nil {
+			return 0, fmt.Errorf("Account %s: %w", name, err)
+		}
+		a.accounts[name] = acc
+	}
+	return len(records), nil
+}
+
+//Login checks the password of the account and returns a new session token for
+//its player
+func (a *Authenticator) Login(name, password string) (string, error) {
+	a.m.Lock()
+	acc, ok := a.accounts[name]
+	a.m.Unlock()
+	if !ok {
+		bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+		return "", errors.New("Invalid name or password")
+	}
+	if bcrypt.CompareHashAndPassword(acc.Hash, []byte(password)) != nil {
+		return "", errors.New("Invalid name or password")
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.issue(acc.PlayerID, time.Now())
+}
+
+//issue starts a session and signs its token. The caller must hold the lock.
+func (a *Authenticator) issue(playerID string, now time.Time) (string, error) {
+	//Drop the sessions that have expired on their own
+	for id, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, id)
+		}
+	}
+	c := Claims{
+		SessionID: genID(),
+		PlayerID:  playerID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.TTL).Unix(),
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	a.sessions[c.SessionID] = session{playerID, time.Unix(c.ExpiresAt, 0)}
+	tosign := header + "." + base64.RawURLEncoding.EncodeToString(b)
+	return tosign + "." + a.sign(tosign), nil
+}
+
+func (a *Authenticator) sign(s string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//Verify checks the signature of the token, that it hasn't expired and that its
+//session hasn't been revoked
+func (a *Authenticator) Verify(token string) (Claims, error) {
+	c := Claims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return c, errors.New("Invalid Token")
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0]+"."+parts[1]))) {
+		return c, errors.New("Invalid Signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	if time.Now().After(time.Unix(c.ExpiresAt, 0)) {
+		return c, errors.New("Token has expired")
+	}
+	a.m.Lock()
+	s, ok := a.sessions[c.SessionID]
+	a.m.Unlock()
+	if !ok || s.playerID != c.PlayerID {
+		return c, errors.New("Session has been revoked")
+	}
+	return c, nil
+}
+
+//Revoke ends the session of the token, such as when a player logs out
+func (a *Authenticator) Revoke(token string) error {
+	c, err := a.Verify(token)
+	if err != nil {
+		return err
+	}
+	a.m.Lock()
+	delete(a.sessions, c.SessionID)
+	a.m.Unlock()
+	return nil
+}
+
+//RevokePlayer ends every session of the player
+func (a *Authenticator) RevokePlayer(playerID string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for id, s := range a.sessions {
+		if s.playerID == playerID {
+			delete(a.sessions, id)
+		}
+	}
+}
+
+//Token pulls the session token off of a request, from the Authorization
+//header or the token query parameter, which websockets have to use
+func Token(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+//Middleware verifies the session token of every request that has one, and
+//places the player in the context as its viewer. A request with a bad token is
+//refused, a request without one is passed on without a viewer.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := Token(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := a.Verify(token)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		ctx := sh.WithViewer(r.Context(), sh.PlayerViewer(c.PlayerID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
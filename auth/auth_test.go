@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	a := New([]byte("key"), time.Hour)
+	token, err := a.Register("sean", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Register("sean", "other"); err == nil {
+		t.Fatal("Expected a taken name to be refused")
+	}
+	c, err := a.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Login("sean", "wrong"); err == nil {
+		t.Fatal("Expected a wrong password to be refused")
+	}
+	if _, err = a.Login("nobody", "hunter2"); err == nil {
+		t.Fatal("Expected an unknown name to be refused")
+	}
+	//Logging in again is the same player
+	token2, err := a.Login("sean", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := a.Verify(token2)
+	if err != nil || c2.PlayerID != c.PlayerID || c2.SessionID == c.SessionID {
+		t.Fatal("Expected a new session for the same player", c, c2, err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + strings.Split(token2, ".")[1] + "." + parts[2]
+	if _, err = a.Verify(forged); err == nil {
+		t.Fatal("Expected a tampered token to be refused")
+	}
+	if _, err = New([]byte("other"), time.Hour).Verify(token); err == nil {
+		t.Fatal("Expected a token signed with another key to be refused")
+	}
+
+	if err = a.Revoke(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Verify(token); err == nil {
+		t.Fatal("Expected a revoked token to be refused")
+	}
+	if _, err = a.Verify(token2); err != nil {
+		t.Fatal("Expected the other session to survive", err)
+	}
+	a.RevokePlayer(c.PlayerID)
+	if _, err = a.Verify(token2); err == nil {
+		t.Fatal("Expected every session of the player to be revoked")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	a := New([]byte("key"), -time.Minute)
+	token, err := a.Register("sean", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Verify(token); err == nil {
+		t.Fatal("Expected an expired token to be refused")
+	}
+}
+
+//memAccounts keeps the account records in memory
+type memAccounts map[string][]byte
+
+func (m memAccounts) SaveAccount(name string, record []byte) error {
+	m[name] = record
+	return nil
+}
+
+func (m memAccounts) LoadAccounts() (map[string][]byte, error) { return m, nil }
+
+func TestAccountsPersist(t *testing.T) {
+	store := memAccounts{}
+	a := New([]byte("key"), time.Hour)
+	a.Store = store
+	token, err := a.Register("sean", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := a.Verify(token)
+
+	//After a restart, with a new key, the player logs back in as themselves
+	b := New([]byte("other"), time.Hour)
+	b.Store = store
+	if n, err := b.LoadAccounts(); err != nil || n != 1 {
+		t.Fatal("Expected the account to be restored", n, err)
+	}
+	if _, err = b.Verify(token); err == nil {
+		t.Fatal("Expected the old session not to survive the restart")
+	}
+	token, err = b.Login("sean", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := b.Verify(token)
+	if err != nil || c2.PlayerID != c.PlayerID {
+		t.Fatal("Expected the same player after a restart", c, c2, err)
+	}
+	if _, err = b.Register("sean", "other"); err == nil {
+		t.Fatal("Expected a restored name to stay taken")
+	}
+}
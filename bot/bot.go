@@ -0,0 +1,344 @@
+//Package bot provides computer players for secret hitler games. A bot plays
+//like any other client, it only sees the events filtered for its player id and
+//answers the requests sent to it by submitting events of its own.
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//Strategy makes the decisions for a bot. Every method is given the bots view of
+//the game along with the choices it is allowed to make, and must return one of
+//those choices.
+type Strategy interface {
+	//Nominate picks a chancellor from the eligible candidates
+	Nominate(v *View, candidates []string) string
+	//Vote decides on the proposed government
+	Vote(v *View, presidentID, chancellorID string) bool
+	//Legislate picks the policy to discard. If canVeto is set the bot is the
+	//chancellor and may also ask the president to veto the agenda.
+	Legislate(v *View, policies []string, canVeto bool) (discard string, veto bool)
+	//ExecutiveAction picks the target of an investigation, special election or
+	//execution from the eligible candidates
+	ExecutiveAction(v *View, action string, candidates []string) string
+}
+
+//Election is a government that was voted on, as seen by a bot
+type Election struct {
+	RoundID      int
+	PresidentID  string
+	ChancellorID string
+	Votes        []sh.Vote
+	Succeeded    bool
+	//EnactedPolicy is the policy the government passed, if any
+	EnactedPolicy string
+}
+
+//View is everything a bot knows about the game. It is built up only from the
+//filtered events the bot has received.
+type View struct {
+	PlayerID string
+	Game     sh.Game
+	//Elections holds the result of every vote in the game so far
+	Elections []Election
+	//Parties are the party memberships the bot has learned from investigations
+	Parties map[string]string
+	//Peeked are the policies the bot saw on top of the draw pile, if any
+	Peeked []string
+}
+
+//Me returns the bots own player
+func (v *View) Me() sh.Player {
+	p, _ := v.Game.GetPlayerByID(v.PlayerID)
+	return p
+}
+
+//Party returns the party of the player if the bot knows it, or an empty string
+func (v *View) Party(playerID string) string {
+	if p, ok := v.Parties[playerID]; ok {
+		return p
+	}
+	if p, err := v.Game.GetPlayerByID(playerID); err == nil && p.Party != sh.PartyMasked {
+		return p.Party
+	}
+	return ""
+}
+
+//Role returns the role of the player if the bot knows it, or an empty string
+func (v *View) Role(playerID string) string {
+	if p, err := v.Game.GetPlayerByID(playerID); err == nil && p.Role != sh.RoleMasked {
+		return p.Role
+	}
+	return ""
+}
+
+//Candidates returns the players the bot is currently allowed to target with the
+//given type of player event
+func (v *View) Candidates(eventType string) []string {
+	ctx := sh.WithViewer(context.Background(), sh.PlayerViewer(v.PlayerID))
+	ret := []string{}
+	for _, p := range v.Game.Players {
+		e := sh.PlayerPlayerEvent{
+			BaseEvent:     sh.BaseEvent{Type: eventType},
+			PlayerID:      v.PlayerID,
+			OtherPlayerID: p.ID,
+		}
+		if v.Game.Validate(ctx, e) == nil {
+			ret = append(ret, p.ID)
+		}
+	}
+	return ret
+}
+
+func (v *View) observe(e sh.Event) {
+	switch te := e.(type) {
+	case sh.VoteResultEvent:
+		v.Elections = append(v.Elections, Election{
+			RoundID:      te.RoundID,
+			PresidentID:  v.Game.Round.PresidentID,
+			ChancellorID: v.Game.Round.ChancellorID,
+			Votes:        te.Votes,
+			Succeeded:    te.Succeeded,
+		})
+	case sh.GameEvent:
+		p := te.Game.Round.EnactedPolicy
+		if (p == sh.PolicyLiberal || p == sh.PolicyFascist) && len(v.Elections) > 0 {
+			if el := &v.Elections[len(v.Elections)-1]; el.RoundID == v.Game.Round.ID {
+				el.EnactedPolicy = p
+			}
+		}
+	case sh.InformationEvent:
+		if te.PlayerID == v.PlayerID {
+			if te.OtherPlayerID != "" && te.Party != "" {
+				v.Parties[te.OtherPlayerID] = te.Party
+			}
+			if len(te.Policies) > 0 {
+				v.Peeked = te.Policies
+			}
+		}
+	}
+	g, _, err := v.Game.Apply(e)
+	if err != nil {
+		return
+	}
+	v.Game = g
+}
+
+//Bot is a computer player. Events from the game are given to Handle, which
+//returns the events the bot wants to submit in response.
+type Bot struct {
+	ID       string
+	Strategy Strategy
+	View     View
+	//Logger logs the events of the bot the game refused, slog.Default() when
+	//nil
+	Logger *slog.Logger
+
+	pending map[int]sh.Event
+}
+
+func New(id string, s Strategy) *Bot {
+	b := new(Bot)
+	b.ID = id
+	b.Strategy = s
+	b.View.PlayerID = id
+	b.View.Parties = make(map[string]string)
+	b.pending = make(map[int]sh.Event)
+	return b
+}
+
+func (b *Bot) logger() *slog.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return slog.Default()
+}
+
+//Context returns a context authenticated as the bot, to submit its events with
+func (b *Bot) Context(ctx context.Context) context.Context {
+	return sh.WithViewer(ctx, sh.PlayerViewer(b.ID))
+}
+
+//Handle filters the event for the bot, updates its view of the game and returns
+//the events it wants to submit in response. Events are expected to follow on
+//from the last event in the view, any that arrive early are held until the
+//events before them have been handled.
+func (b *Bot) Handle(e sh.Event) []sh.Event {
+	if e.GetID() <= b.View.Game.EventID {
+		return nil
+	}
+	b.pending[e.GetID()] = e
+	ret := []sh.Event{}
+	for {
+		next, ok := b.pending[b.View.Game.EventID+1]
+		if !ok {
+			return ret
+		}
+		delete(b.pending, next.GetID())
+		fe := next.Filter(b.Context(context.Background()))
+		b.View.observe(fe)
+		//Keep in step with the game even if the event couldn't be applied
+		b.View.Game.EventID = next.GetID()
+		if re, ok := fe.(sh.RequestEvent); ok {
+			ret = append(ret, b.respond(re)...)
+		}
+	}
+}
+
+func (b *Bot) respond(r sh.RequestEvent) []sh.Event {
+	v := &b.View
+	me, err := v.Game.GetPlayerByID(b.ID)
+	if err != nil || me.ExecutedBy != "" {
+		return nil
+	}
+	if r.PlayerID != b.ID && r.PlayerID != sh.PlayerIDAll {
+		return nil
+	}
+	switch r.Type {
+	case sh.TypeRequestAcknowledge:
+		if me.Ack {
+			return nil
+		}
+		return []sh.Event{sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerAcknowledge},
+			Player:    sh.Player{ID: b.ID, Party: me.Party, Role: me.Role},
+		}}
+	case sh.TypeRequestNominate:
+		c := v.Candidates(sh.TypePlayerNominate)
+		if len(c) == 0 {
+			return nil
+		}
+		return []sh.Event{sh.PlayerPlayerEvent{
+			BaseEvent:     sh.BaseEvent{Type: sh.TypePlayerNominate},
+			PlayerID:      b.ID,
+			OtherPlayerID: b.Strategy.Nominate(v, c),
+		}}
+	case sh.TypeRequestVote:
+		return []sh.Event{sh.PlayerVoteEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerVote},
+			PlayerID:  b.ID,
+			Vote:      b.Strategy.Vote(v, r.PresidentID, r.ChancellorID),
+		}}
+	case sh.TypeRequestLegislate:
+		//The bot can't see the policy left after a veto request, so it always
+		//agrees to the veto
+		if r.Veto {
+			return []sh.Event{sh.PlayerLegislateEvent{
+				BaseEvent: sh.BaseEvent{Type: sh.TypePlayerLegislate},
+				PlayerID:  b.ID,
+				Veto:      true,
+			}}
+		}
+		if len(r.Policies) == 0 {
+			return nil
+		}
+		discard, veto := b.Strategy.Legislate(v, r.Policies, r.VetoPossible && len(r.Policies) == 2)
+		return []sh.Event{sh.PlayerLegislateEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerLegislate},
+			PlayerID:  b.ID,
+			Discard:   discard,
+			Veto:      veto && r.VetoPossible && len(r.Policies) == 2,
+		}}
+	case sh.TypeRequestExecutiveAction:
+		typ := ""
+		switch r.ExecutiveAction {
+		case sh.ExecutiveActionInvestigate:
+			typ = sh.TypePlayerInvestigate
+		case sh.ExecutiveActionSpecialElection:
+			typ = sh.TypePlayerSpecialElection
+		case sh.ExecutiveActionExecute:
+			typ = sh.TypePlayerExecute
+		default:
+			return nil
+		}
+		c := v.Candidates(typ)
+		if len(c) == 0 {
+			return nil
+		}
+		return []sh.Event{sh.PlayerPlayerEvent{
+			BaseEvent:     sh.BaseEvent{Type: typ},
+			PlayerID:      b.ID,
+			OtherPlayerID: b.Strategy.ExecutiveAction(v, r.ExecutiveAction, c),
+		}}
+	}
+	return nil
+}
+
+//Play subscribes the bot to the game, joins it and readies up if it hasn't
+//already, and then answers requests until the game is finished or the context
+//is done.
+func (b *Bot) Play(ctx context.Context, g *sh.SecretHitler) error {
+	key := "bot:" + b.ID
+	c := make(chan sh.Event, 10)
+	g.AddSubscriber(key, c)
+	defer g.RemoveSubscriber(key)
+	gone := g.Disconnected(key)
+
+	pctx := b.Context(ctx)
+	b.View.Game = g.FilteredGame(pctx)
+	me, err := b.View.Game.GetPlayerByID(b.ID)
+	if err != nil {
+		err = g.SubmitEvent(pctx, sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+			Player:    sh.Player{ID: b.ID},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !me.Ready && b.View.Game.State == sh.GameStateLobby {
+		err = g.SubmitEvent(pctx, sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerReady},
+			Player:    sh.Player{ID: b.ID},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	//Submit from another goroutine, so the bot keeps reading its events while it
+	//waits on the game
+	out := make(chan sh.Event)
+	done := make(chan struct{})
+	gameID := b.View.Game.ID
+	go func() {
+		defer close(done)
+		for e := range out {
+			if err := g.SubmitEvent(pctx, e); err != nil {
+				b.logger().Error("bot event refused", "gameId", gameID, "playerId", b.ID, "eventType", e.GetType(), "err", err)
+			}
+		}
+	}()
+	defer func() {
+		close(out)
+		<-done
+	}()
+
+	queue := []sh.Event{}
+	for {
+		var send chan<- sh.Event
+		var next sh.Event
+		if len(queue) > 0 {
+			send = out
+			next = queue[0]
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-gone:
+			return errors.New("Bot fell behind and was disconnected")
+		case e := <-c:
+			queue = append(queue, b.Handle(e)...)
+			if b.View.Game.State == sh.GameStateFinished {
+				return nil
+			}
+		case send <- next:
+			queue = queue[1:]
+		}
+	}
+}
+
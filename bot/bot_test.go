@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//playGame runs a full game between the bots and returns the finished game
+func playGame(t *testing.T, bots []*Bot) sh.Game {
+	g := sh.NewSecretHitler()
+	defer g.Close()
+	//Join everyone up front so the game doesn't start before all the bots are in
+	for _, b := range bots {
+		err := g.SubmitEvent(b.Context(context.Background()), sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+			Player:    sh.Player{ID: b.ID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errs := make(chan error, len(bots))
+	wg := sync.WaitGroup{}
+	for _, b := range bots {
+		wg.Add(1)
+		go func(b *Bot) {
+			defer wg.Done()
+			errs <- b.Play(ctx, g)
+		}(b)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g.FilteredGame(sh.WithViewer(context.Background(), sh.AdminViewer()))
+}
+
+func TestHeuristicBots(t *testing.T) {
+	for n := 5; n <= 10; n++ {
+		bots := []*Bot{}
+		for i := 0; i < n; i++ {
+			bots = append(bots, New(strconv.Itoa(i), Heuristic{}))
+		}
+		g := playGame(t, bots)
+		if g.State != sh.GameStateFinished || g.WinningParty == "" {
+			t.Fatal("Expected the game to finish with a winner", n, g.State)
+		}
+		//Every bot should have ended up with the same view of the outcome
+		for _, b := range bots {
+			if b.View.Game.WinningParty != g.WinningParty {
+				t.Fatal("Expected bot to see the winning party", b.ID, b.View.Game.WinningParty, g.WinningParty)
+			}
+		}
+	}
+}
+
+func TestRandomBots(t *testing.T) {
+	for n := 5; n <= 10; n++ {
+		bots := []*Bot{}
+		for i := 0; i < n; i++ {
+			bots = append(bots, New(strconv.Itoa(i), NewRandom(int64(i))))
+		}
+		g := playGame(t, bots)
+		if g.State != sh.GameStateFinished {
+			t.Fatal("Expected the game to finish", n, g.State)
+		}
+	}
+}
+
+func TestBotOnlySeesFilteredEvents(t *testing.T) {
+	b := New("1", Heuristic{})
+	players := []sh.Player{}
+	for i := 1; i <= 5; i++ {
+		p := sh.Player{ID: strconv.Itoa(i), Party: sh.PartyLiberal, Role: sh.RoleLiberal}
+		if i == 2 {
+			p.Party, p.Role = sh.PartyFascist, sh.RoleHitler
+		}
+		players = append(players, p)
+	}
+	b.Handle(sh.GameEvent{
+		BaseEvent: sh.BaseEvent{ID: 1, Type: sh.TypeGameUpdate},
+		Game:      sh.Game{State: sh.GameStateInit, Players: players},
+	})
+	if b.View.Role("2") != "" {
+		t.Fatal("Expected a liberal bot not to know hitler")
+	}
+	//An out of order event is held until the one before it arrives
+	ack := b.Handle(sh.RequestEvent{
+		BaseEvent: sh.BaseEvent{ID: 3, Type: sh.TypeRequestAcknowledge},
+		PlayerID:  sh.PlayerIDAll,
+	})
+	if len(ack) != 0 {
+		t.Fatal("Expected the request to wait for the earlier event", ack)
+	}
+	ack = b.Handle(sh.PlayerEvent{
+		BaseEvent: sh.BaseEvent{ID: 2, Type: sh.TypePlayerAcknowledge},
+		Player:    sh.Player{ID: "3", Party: sh.PartyLiberal, Role: sh.RoleLiberal},
+	})
+	if len(ack) != 1 {
+		t.Fatal("Expected the bot to acknowledge its role", ack)
+	}
+	if pe := ack[0].(sh.PlayerEvent); pe.Player.Role != sh.RoleLiberal || pe.Player.ID != "1" {
+		t.Fatal("Expected the bot to acknowledge its own role", pe)
+	}
+}
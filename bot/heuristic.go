@@ -0,0 +1,235 @@
+package bot
+
+import (
+	sh "github.com/murphysean/secrethitler"
+)
+
+//Heuristic plays by a few simple rules of thumb for whichever role it was dealt.
+//The role isn't known until the game starts, so each decision is handed to the
+//Liberal, Fascist or Hitler strategy according to the bots role at the time.
+type Heuristic struct{}
+
+func (Heuristic) strategy(v *View) Strategy {
+	switch v.Me().Role {
+	case sh.RoleFascist:
+		return Fascist{}
+	case sh.RoleHitler:
+		return Hitler{}
+	}
+	return Liberal{}
+}
+
+func (h Heuristic) Nominate(v *View, candidates []string) string {
+	return h.strategy(v).Nominate(v, candidates)
+}
+
+func (h Heuristic) Vote(v *View, presidentID, chancellorID string) bool {
+	return h.strategy(v).Vote(v, presidentID, chancellorID)
+}
+
+func (h Heuristic) Legislate(v *View, policies []string, canVeto bool) (string, bool) {
+	return h.strategy(v).Legislate(v, policies, canVeto)
+}
+
+func (h Heuristic) ExecutiveAction(v *View, action string, candidates []string) string {
+	return h.strategy(v).ExecutiveAction(v, action, candidates)
+}
+
+//suspicion scores how likely each player is to be a fascist from the public
+//record and what the bot has learned itself. Governments that passed fascist
+//policies, and the players that voted them in, are marked up, while liberal
+//governments are marked down. Known parties outweigh everything else.
+func suspicion(v *View) map[string]int {
+	ret := map[string]int{}
+	for _, el := range v.Elections {
+		switch el.EnactedPolicy {
+		case sh.PolicyFascist:
+			ret[el.PresidentID] += 2
+			ret[el.ChancellorID] += 2
+			for _, vote := range el.Votes {
+				if vote.Vote {
+					ret[vote.PlayerID]++
+				}
+			}
+		case sh.PolicyLiberal:
+			ret[el.PresidentID]--
+			ret[el.ChancellorID]--
+		}
+	}
+	for id, party := range v.Parties {
+		if party == sh.PartyFascist {
+			ret[id] += 100
+		} else if party == sh.PartyLiberal {
+			ret[id] -= 100
+		}
+	}
+	ret[v.PlayerID] = -1000
+	return ret
+}
+
+//least returns the candidate with the lowest score, keeping the first on a tie
+func least(candidates []string, score func(string) int) string {
+	ret := candidates[0]
+	for _, c := range candidates[1:] {
+		if score(c) < score(ret) {
+			ret = c
+		}
+	}
+	return ret
+}
+
+func most(candidates []string, score func(string) int) string {
+	return least(candidates, func(id string) int { return -score(id) })
+}
+
+func contains(policies []string, policy string) bool {
+	for _, p := range policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+//Liberal trusts the players with the cleanest record, always passes liberal
+//policies when it can and goes after the most suspicious players.
+type Liberal struct{}
+
+func (Liberal) Nominate(v *View, candidates []string) string {
+	s := suspicion(v)
+	return least(candidates, func(id string) int { return s[id] })
+}
+
+func (Liberal) Vote(v *View, presidentID, chancellorID string) bool {
+	if presidentID == v.PlayerID || chancellorID == v.PlayerID {
+		return true
+	}
+	s := suspicion(v)
+	//Electing hitler now loses the game, so only trust proven players
+	if v.Game.Fascist > 2 && s[chancellorID] > 0 {
+		return false
+	}
+	//Another failed vote enacts a random policy, which favors the fascists
+	if v.Game.ElectionTracker > 1 && s[presidentID] < 100 && s[chancellorID] < 100 {
+		return true
+	}
+	return s[presidentID] <= 1 && s[chancellorID] <= 1
+}
+
+func (Liberal) Legislate(v *View, policies []string, canVeto bool) (string, bool) {
+	if contains(policies, sh.PolicyFascist) {
+		return sh.PolicyFascist, canVeto && !contains(policies, sh.PolicyLiberal)
+	}
+	return policies[0], false
+}
+
+func (Liberal) ExecutiveAction(v *View, action string, candidates []string) string {
+	s := suspicion(v)
+	switch action {
+	case sh.ExecutiveActionSpecialElection:
+		return least(candidates, func(id string) int { return s[id] })
+	case sh.ExecutiveActionInvestigate:
+		//Learn something new, rather than confirm what is already known
+		return most(candidates, func(id string) int {
+			if v.Party(id) != "" {
+				return -1000
+			}
+			return s[id]
+		})
+	}
+	return most(candidates, func(id string) int { return s[id] })
+}
+
+//Fascist knows its team. It pushes its teammates into government, gets hitler
+//elected once three fascist policies are down, passes fascist policies and
+//removes the liberals the table trusts most.
+type Fascist struct{}
+
+//teammate scores fascists ahead of everyone else, and hitler ahead of them all
+//once electing hitler would win the game
+func teammate(v *View, s map[string]int) func(string) int {
+	return func(id string) int {
+		switch {
+		case v.Role(id) == sh.RoleHitler && v.Game.Fascist > 2:
+			return -2000
+		case v.Party(id) == sh.PartyFascist:
+			return -1000 + s[id]
+		}
+		return s[id]
+	}
+}
+
+func (Fascist) Nominate(v *View, candidates []string) string {
+	return least(candidates, teammate(v, suspicion(v)))
+}
+
+func (Fascist) Vote(v *View, presidentID, chancellorID string) bool {
+	if v.Role(chancellorID) == sh.RoleHitler && v.Game.Fascist > 2 {
+		return true
+	}
+	return v.Party(presidentID) == sh.PartyFascist || v.Party(chancellorID) == sh.PartyFascist
+}
+
+func (Fascist) Legislate(v *View, policies []string, canVeto bool) (string, bool) {
+	if contains(policies, sh.PolicyLiberal) {
+		return sh.PolicyLiberal, canVeto && !contains(policies, sh.PolicyFascist)
+	}
+	return policies[0], false
+}
+
+func (Fascist) ExecutiveAction(v *View, action string, candidates []string) string {
+	s := suspicion(v)
+	switch action {
+	case sh.ExecutiveActionSpecialElection:
+		return least(candidates, teammate(v, s))
+	case sh.ExecutiveActionInvestigate:
+		//Investigating a teammate lets the president vouch for them
+		return least(candidates, teammate(v, s))
+	}
+	//Take out the liberal the table trusts the most
+	return most(candidates, func(id string) int {
+		if v.Party(id) == sh.PartyFascist {
+			return -1000
+		}
+		return -s[id]
+	})
+}
+
+//Hitler needs to look like a liberal to get elected chancellor, so it plays
+//like one until the fascists are a single policy away from winning. If it
+//knows its team it will still back them once it can be elected.
+type Hitler struct{}
+
+func (Hitler) Nominate(v *View, candidates []string) string {
+	if v.Game.Fascist > 2 {
+		return Fascist{}.Nominate(v, candidates)
+	}
+	return Liberal{}.Nominate(v, candidates)
+}
+
+func (Hitler) Vote(v *View, presidentID, chancellorID string) bool {
+	if presidentID == v.PlayerID || chancellorID == v.PlayerID {
+		return true
+	}
+	if v.Game.Fascist > 2 && (v.Party(presidentID) == sh.PartyFascist || v.Party(chancellorID) == sh.PartyFascist) {
+		return true
+	}
+	return Liberal{}.Vote(v, presidentID, chancellorID)
+}
+
+func (Hitler) Legislate(v *View, policies []string, canVeto bool) (string, bool) {
+	if v.Game.Fascist > 4 {
+		return Fascist{}.Legislate(v, policies, canVeto)
+	}
+	return Liberal{}.Legislate(v, policies, canVeto)
+}
+
+func (Hitler) ExecutiveAction(v *View, action string, candidates []string) string {
+	//In small games hitler knows the team, and can play like a fascist
+	for _, p := range v.Game.Players {
+		if p.ID != v.PlayerID && v.Role(p.ID) == sh.RoleFascist {
+			return Fascist{}.ExecutiveAction(v, action, candidates)
+		}
+	}
+	return Liberal{}.ExecutiveAction(v, action, candidates)
+}
@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"math/rand"
+)
+
+//Random makes every decision at random. It is useful as a baseline, and to push
+//games down paths a sensible player never would.
+type Random struct {
+	rng *rand.Rand
+}
+
+func NewRandom(seed int64) *Random {
+	return &Random{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Random) pick(choices []string) string {
+	return choices[r.rng.Intn(len(choices))]
+}
+
+func (r *Random) Nominate(v *View, candidates []string) string {
+	return r.pick(candidates)
+}
+
+func (r *Random) Vote(v *View, presidentID, chancellorID string) bool {
+	return r.rng.Intn(2) == 0
+}
+
+func (r *Random) Legislate(v *View, policies []string, canVeto bool) (string, bool) {
+	return r.pick(policies), canVeto && r.rng.Intn(2) == 0
+}
+
+func (r *Random) ExecutiveAction(v *View, action string, candidates []string) string {
+	return r.pick(candidates)
+}
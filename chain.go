@@ -0,0 +1,191 @@
+package sh
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//LogLink is a line of a chained event log. Each event is written along with the
+//hash of the link before it, so editing, dropping or reordering an event breaks
+//every link after it. With a key each link is also signed, so the chain can't
+//be rebuilt by someone without the key.
+type LogLink struct {
+	Prev  string          `json:"prev"`
+	Hash  string          `json:"hash"`
+	MAC   string          `json:"mac,omitempty"`
+	Event json.RawMessage `json:"event"`
+}
+
+//Chain links the events written to a log
+type Chain struct {
+	//Key signs every link with an HMAC when set
+	Key []byte
+	//Prev is the hash of the last link, empty before the first one
+	Prev string
+}
+
+func (c *Chain) hash(prev string, event []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write(event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Chain) mac(hash string) string {
+	m := hmac.New(sha256.New, c.Key)
+	m.Write([]byte(hash))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+//Link chains the json of an event onto the last link
+func (c *Chain) Link(event []byte) LogLink {
+	l := LogLink{Prev: c.Prev, Hash: c.hash(c.Prev, event), Event: event}
+	if c.Key != nil {
+		l.MAC = c.mac(l.Hash)
+	}
+	c.Prev = l.Hash
+	return l
+}
+
+//Check checks that the link follows the last one and hasn't been changed. The
+//signature is only checked when the chain has a key.
+func (c *Chain) Check(l LogLink) error {
+	if l.Prev != c.Prev {
+		return errors.New("Link does not follow the previous one")
+	}
+	if l.Hash != c.hash(l.Prev, l.Event) {
+		return errors.New("Event does not match its hash")
+	}
+	if c.Key != nil && !hmac.Equal([]byte(l.MAC), []byte(c.mac(l.Hash))) {
+		return errors.New("Invalid Signature")
+	}
+	c.Prev = l.Hash
+	return nil
+}
+
+//LogError is the first broken link found in an event log
+type LogError struct {
+	//Line is the line of the log, starting at 1
+	Line    int
+	EventID int
+	Problem string
+}
+
+func (e *LogError) Error() string {
+	return fmt.Sprintf("Event log broken at line %d (event %d): %s", e.Line, e.EventID, e.Problem)
+}
+
+//logReader reads the events of a log written by SubmitEvent one at a time. The
+//first line decides whether the log is chained, after which every line must
+//be the same, and each link is checked as it is read.
+type logReader struct {
+	d       *json.Decoder
+	chain   Chain
+	chained bool
+	line    int
+}
+
+func newLogReader(r io.Reader, key []byte) *logReader {
+	return &logReader{d: json.NewDecoder(r), chain: Chain{Key: key}}
+}
+
+func (lr *logReader) next() (Event, error) {
+	var rm json.RawMessage
+	if err := lr.d.Decode(&rm); err != nil {
+		return nil, err
+	}
+	lr.line++
+	l := LogLink{}
+	if err := json.Unmarshal(rm, &l); err != nil {
+		return nil, err
+	}
+	if lr.line == 1 {
+		lr.chained = l.Event != nil
+	}
+	if !lr.chained {
+		if l.Event != nil {
+			return nil, &LogError{Line: lr.line, Problem: "Chained event in an unchained log"}
+		}
+		return UnmarshalEvent(rm)
+	}
+	if l.Event == nil {
+		e, _ := UnmarshalEvent(rm)
+		return nil, lr.broken(e, "Event is missing its link")
+	}
+	e, err := UnmarshalEvent(l.Event)
+	if err != nil {
+		return nil, err
+	}
+	if err := lr.chain.Check(l); err != nil {
+		return nil, lr.broken(e, err.Error())
+	}
+	return e, nil
+}
+
+func (lr *logReader) readAll() ([]Event, error) {
+	events := []Event{}
+	for {
+		e, err := lr.next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
+
+func (lr *logReader) broken(e Event, problem string) error {
+	le := &LogError{Line: lr.line, Problem: problem}
+	if e != nil {
+		le.EventID = e.GetID()
+	}
+	return le
+}
+
+//VerifyLog walks an event log and returns the first broken link as a
+//*LogError. A chained log is checked link by link, and its signatures as well
+//when given the key it was written with. A log that isn't chained can't be
+//checked, and is only read. It returns the number of events read.
+func VerifyLog(r io.Reader, key []byte) (int, error) {
+	lr := newLogReader(r, key)
+	n := 0
+	for {
+		_, err := lr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if !lr.chained && n > 0 {
+		return n, &LogError{Line: 1, Problem: "Log is not chained"}
+	}
+	return n, nil
+}
+
+//writeLog writes the event to the log, linked to the event before it when the
+//log is chained. The chain only moves on once the link is written.
+func (sh *SecretHitler) writeLog(e Event) error {
+	if sh.Chain == nil {
+		return json.NewEncoder(sh.Log).Encode(e)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	c := *sh.Chain
+	if err = json.NewEncoder(sh.Log).Encode(c.Link(b)); err != nil {
+		return err
+	}
+	*sh.Chain = c
+	return nil
+}
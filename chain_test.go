@@ -0,0 +1,155 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//chainedLog plays five players joining a game with a chained log
+func chainedLog(t *testing.T, key []byte) string {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	buf := new(bytes.Buffer)
+	sh.Log = buf
+	sh.Chain = &Chain{Key: key}
+	for i := 1; i <= 5; i++ {
+		id := strconv.Itoa(i)
+		ctx := WithViewer(context.Background(), PlayerViewer(id))
+		err := sh.SubmitEvent(ctx, PlayerEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerJoin},
+			Player:    Player{ID: id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestVerifyLog(t *testing.T) {
+	key := []byte("testingtesting123")
+	log := chainedLog(t, key)
+	n, err := VerifyLog(strings.NewReader(log), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatal("Expected every event to be read", n)
+	}
+
+	lines := strings.SplitAfter(log, "\n")
+	tests := map[string]struct {
+		log  string
+		key  []byte
+		line int
+	}{
+		"edited":    {strings.Replace(log, `"id":"3"`, `"id":"6"`, 1), key, 3},
+		"dropped":   {lines[0] + lines[2] + lines[3], key, 2},
+		"reordered": {lines[1] + lines[0], key, 1},
+		"unlinked":  {lines[0] + `{"id":2,"type":"player.join","moment":"2020-01-01T00:00:00Z","playerId":"2"}` + "\n", key, 2},
+		"wrong key": {log, []byte("guess"), 1},
+	}
+	for name, tt := range tests {
+		_, err := VerifyLog(strings.NewReader(tt.log), tt.key)
+		le := new(LogError)
+		if !errors.As(err, &le) {
+			t.Fatal("Expected the log to be broken:", name, err)
+		}
+		if le.Line != tt.line {
+			t.Fatal("Expected the first broken link:", name, tt.line, le)
+		}
+	}
+
+	//Without the key the links are still checked, but anyone could have made them
+	if _, err := VerifyLog(strings.NewReader(log), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadEventLogTampered(t *testing.T) {
+	log := strings.Replace(chainedLog(t, nil), `"id":"4"`, `"id":"7"`, 1)
+	c := make(chan Event, 10)
+	err := ReadEventLog(strings.NewReader(log), c)
+	if le := new(LogError); !errors.As(err, &le) || le.EventID != 4 {
+		t.Fatal("Expected the tampered event to be refused", err)
+	}
+	n := 0
+	for range c {
+		n++
+	}
+	if n != 3 {
+		t.Fatal("Expected the events before the tampering to be read", n)
+	}
+	if _, err := LoadSecretHitler(strings.NewReader(log)); err == nil {
+		t.Fatal("Expected a tampered log to be refused")
+	}
+}
+
+func TestLoadChainedLog(t *testing.T) {
+	log := chainedLog(t, nil)
+	appended := new(bytes.Buffer)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(log), appended}
+	sh, err := LoadSecretHitler(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	if sh.Chain == nil {
+		t.Fatal("Expected the chain to be continued")
+	}
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	if err = sh.SubmitEvent(ctx, PlayerEvent{BaseEvent: BaseEvent{Type: TypePlayerReady}, Player: Player{ID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyLog(strings.NewReader(log+appended.String()), nil); err != nil || n != 6 {
+		t.Fatal("Expected the new event to be linked to the log", n, err)
+	}
+}
+
+func TestLoadSignedLog(t *testing.T) {
+	key := []byte("testingtesting123")
+	log := chainedLog(t, key)
+	appended := new(bytes.Buffer)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(log), appended}
+	sh, err := LoadSignedSecretHitler(rw, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	if err = sh.SubmitEvent(ctx, PlayerEvent{BaseEvent: BaseEvent{Type: TypePlayerReady}, Player: Player{ID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyLog(strings.NewReader(log+appended.String()), key); err != nil || n != 6 {
+		t.Fatal("Expected the new event to be signed", n, err)
+	}
+
+	//A log that isn't chained, or wasn't signed with the key, is refused
+	plain := NewSecretHitler()
+	buf := new(bytes.Buffer)
+	plain.Log = buf
+	if err := join(plain, "1"); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	for name, l := range map[string]string{"unchained": buf.String(), "unsigned": chainedLog(t, nil), "wrong key": chainedLog(t, []byte("guess"))} {
+		_, err := LoadSignedSecretHitler(strings.NewReader(l), key)
+		if le := new(LogError); !errors.As(err, &le) || le.Line != 1 {
+			t.Fatal("Expected the log to be refused:", name, err)
+		}
+	}
+	if _, err := LoadSignedSecretHitler(strings.NewReader(log), nil); err == nil {
+		t.Fatal("Expected a key to be required")
+	}
+}
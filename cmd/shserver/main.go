@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/auth"
+	"github.com/murphysean/secrethitler/server"
+	"github.com/murphysean/secrethitler/store"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address for the http server to listen on")
+	retention := flag.Duration("retention", time.Hour, "how long to keep finished games around")
+	storeKind := flag.String("store", "", "where to persist games, either file or bolt")
+	data := flag.String("data", "data", "directory for the file store, or database path for the bolt store")
+	fsync := flag.Bool("sync", true, "fsync every event as it is persisted")
+	snapshots := flag.Int("snapshots", 50, "number of events between game snapshots, 0 to disable")
+	verify := flag.Bool("verify-snapshots", false, "replay every game from the start when loading and compare with its snapshot")
+	invariants := flag.String("invariants", "off", "check the game invariants after every event, either off, flag to log broken invariants or reject to also refuse the event")
+	turn := flag.Duration("turn-timeout", 0, "how long players have to respond to a request before the engine acts for them, 0 to wait forever")
+	delayEvents := flag.Int("omniscient-delay-events", 0, "number of events omniscient spectators are held back by, the view is refused unless a delay is set and -auth is on")
+	delayTime := flag.Duration("omniscient-delay", 0, "how long omniscient spectators are held back by")
+	accounts := flag.Bool("auth", false, "require players to register and log in, signing sessions with the key in SH_AUTH_KEY or a random one")
+	ttl := flag.Duration("session-ttl", 24*time.Hour, "how long a session token is good for")
+	honest := flag.Bool("honest-claims", false, "check the claims players make with a token, and mark them verified when they match it")
+	queue := flag.Int("queue-size", sh.DefaultQueueSize, "number of events that can wait on each subscriber")
+	backpressure := flag.String("backpressure", "disconnect", "what to do with a subscriber whose queue is full, either disconnect, drop-oldest or block")
+	blockTimeout := flag.Duration("block-timeout", time.Second, "how long the block backpressure waits on a subscriber before disconnecting it")
+	tokenTTL := flag.Duration("token-ttl", 0, "how long the tokens players are shown secrets with can be asserted, 0 for the whole game")
+	logFormat := flag.String("log-format", "text", "how to write the logs, either text or json")
+	flag.Parse()
+
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		log.Fatal("Unknown log format: ", *logFormat)
+	}
+
+	lobby := sh.NewLobby(*retention)
+	lobby.SnapshotInterval = *snapshots
+	lobby.VerifySnapshots = *verify
+	lobby.TokenTTL = *tokenTTL
+	lobby.Logger = slog.Default()
+	lobby.HonestClaims = *honest
+	switch *invariants {
+	case "off":
+	case "flag":
+		lobby.CheckInvariants = sh.InvariantsFlag
+	case "reject":
+		lobby.CheckInvariants = sh.InvariantsReject
+	default:
+		log.Fatal("Unknown invariant mode: ", *invariants)
+	}
+	lobby.Fanout = sh.SubscriberOptions{QueueSize: *queue, BlockTimeout: *blockTimeout}
+	switch *backpressure {
+	case "disconnect":
+		lobby.Fanout.Backpressure = sh.BackpressureDisconnect
+	case "drop-oldest":
+		lobby.Fanout.Backpressure = sh.BackpressureDropOldest
+	case "block":
+		lobby.Fanout.Backpressure = sh.BackpressureBlock
+		if *blockTimeout <= 0 {
+			log.Fatal("The block backpressure needs a block-timeout, as it holds up the game while it waits")
+		}
+	default:
+		log.Fatal("Unknown backpressure: ", *backpressure)
+	}
+	lobby.Timeouts = sh.Timeouts{
+		Acknowledge:     *turn,
+		Nominate:        *turn,
+		Vote:            *turn,
+		Legislate:       *turn,
+		ExecutiveAction: *turn,
+	}
+	//The stored events and snapshots hold every secret of the games
+	var cipher *sh.LogCipher
+	if k := os.Getenv("SH_STORE_KEY"); k != "" {
+		var err error
+		if cipher, err = sh.NewLogCipher([]byte(k)); err != nil {
+			log.Fatal("SH_STORE_KEY: ", err)
+		}
+	}
+	switch *storeKind {
+	case "":
+	case "file":
+		fs, err := store.NewFileStore(*data, store.FileOptions{Sync: *fsync, Cipher: cipher})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fs.Close()
+		lobby.Store = fs
+	case "bolt":
+		bs, err := store.NewBoltStore(*data, store.BoltOptions{NoSync: !*fsync, Timeout: time.Second, Cipher: cipher})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer bs.Close()
+		lobby.Store = bs
+	default:
+		log.Fatal("Unknown store: ", *storeKind)
+	}
+	if lobby.Store != nil {
+		ids, err := lobby.LoadGames()
+		if err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("restored games", "games", len(ids))
+	}
+	go lobby.Run(context.Background(), time.Minute)
+	s := server.NewServer(lobby)
+	s.OmniscientDelayEvents = *delayEvents
+	s.OmniscientDelayTime = *delayTime
+	if *accounts {
+		key := []byte(os.Getenv("SH_AUTH_KEY"))
+		if len(key) == 0 {
+			//Sessions won't survive a restart, but the players can log back in
+			key = make([]byte, 32)
+			rand.Read(key)
+		}
+		s.Auth = auth.New(key, *ttl)
+		//The accounts are kept with the games, so their players can resume them
+		if as, ok := lobby.Store.(auth.AccountStore); ok {
+			s.Auth.Store = as
+			n, err := s.Auth.LoadAccounts()
+			if err != nil {
+				log.Fatal(err)
+			}
+			slog.Info("restored accounts", "accounts", n)
+		} else {
+			slog.Info("accounts are kept in memory, set a -store to keep them across restarts")
+		}
+	}
+	//Stop taking requests on an interrupt, and close the games so their
+	//subscribers are drained and their logs flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *addr, Handler: s}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("listening", "addr", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	if err := lobby.Close(); err != nil {
+		slog.Error("games not closed cleanly", "err", err)
+	}
+}
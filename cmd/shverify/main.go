@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//verify checks a single log file, returning the number of events in it
+func verify(path string, key []byte, c *sh.LogCipher) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if c != nil {
+		return sh.VerifyLog(sh.DecryptLog(f, c), key)
+	}
+	return sh.VerifyLog(f, key)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: shverify [log ...]")
+		fmt.Fprintln(os.Stderr, "Checks the links of chained event logs, and their signatures with the key in SH_LOG_KEY when it is set.")
+		fmt.Fprintln(os.Stderr, "Encrypted logs are decrypted with the key in SH_LOG_CIPHER_KEY.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var key []byte
+	if k := os.Getenv("SH_LOG_KEY"); k != "" {
+		key = []byte(k)
+	}
+	var c *sh.LogCipher
+	if k := os.Getenv("SH_LOG_CIPHER_KEY"); k != "" {
+		var err error
+		if c, err = sh.NewLogCipher([]byte(k)); err != nil {
+			fmt.Fprintln(os.Stderr, "SH_LOG_CIPHER_KEY:", err)
+			os.Exit(2)
+		}
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		n, err := verify(path, key, c)
+		if err != nil {
+			failed = true
+			fmt.Printf("%s: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s: ok, %d events\n", path, n)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/simulate"
+)
+
+var strategies = map[string]simulate.StrategyFunc{
+	"heuristic": simulate.Heuristic,
+	"random":    simulate.Random,
+	"mixed":     simulate.Mixed,
+}
+
+//parsePlayers reads a comma separated list of player counts or ranges, such as
+//5-7,10
+func parsePlayers(s string) ([]int, error) {
+	ret := []int{}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(hi); err != nil {
+				return nil, err
+			}
+		}
+		for n := from; n <= to; n++ {
+			if n < 5 || n > 10 {
+				return nil, fmt.Errorf("Player count %d must be from 5 to 10", n)
+			}
+			ret = append(ret, n)
+		}
+	}
+	return ret, nil
+}
+
+func main() {
+	games := flag.Int("games", 1000, "number of games to play for each player count")
+	players := flag.String("players", "5-10", "player counts to simulate, as a comma separated list of counts or ranges")
+	seed := flag.Int64("seed", 0, "the games are seeded one after another from this seed")
+	strategy := flag.String("strategy", "heuristic", "how the bots play, one of heuristic, random or mixed")
+	flag.Parse()
+
+	counts, err := parsePlayers(*players)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sf, ok := strategies[*strategy]
+	if !ok {
+		log.Fatal("Unknown strategy: ", *strategy)
+	}
+	stats := simulate.Run(*games, counts, *seed, sf)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "players\tgames\tliberal\tfascist\tpolicies\thitler chancellor\thitler executed\trounds\tinvestigate\tpeek\tspecial election\texecute\tfailed\t")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%d\t%d\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t%d\t\n",
+			s.Players, s.Games,
+			100*s.WinRate(sh.PartyLiberal), 100*s.WinRate(sh.PartyFascist),
+			100*s.ConditionRate(sh.ConditionPoliciesEnacted),
+			100*s.ConditionRate(sh.ConditionHitlerChancellor),
+			100*s.ConditionRate(sh.ConditionHitlerExecuted),
+			s.AverageRounds(),
+			s.ActionsPerGame(sh.ExecutiveActionInvestigate),
+			s.ActionsPerGame(sh.ExecutiveActionPeek),
+			s.ActionsPerGame(sh.ExecutiveActionSpecialElection),
+			s.ActionsPerGame(sh.ExecutiveActionExecute),
+			len(s.Failures))
+	}
+	w.Flush()
+
+	failed := false
+	for _, s := range stats {
+		for _, r := range s.Failures {
+			failed = true
+			fmt.Fprintf(os.Stderr, "\n%d players, seed %d: %v\n", r.Players, r.Seed, r.Err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
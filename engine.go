@@ -2,15 +2,12 @@ package sh
 
 import (
 	cr "crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-}
-
 func nextIndex(len, idx int) int {
 	if idx+1 >= len {
 		return 0
@@ -27,10 +24,32 @@ func genUUIDv4() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
 }
 
-func (gs Game) createNextRound() []Event {
+//genSeed returns a random non zero seed for a new game
+func genSeed() int64 {
+	b := make([]byte, 8)
+	cr.Read(b)
+	seed := int64(binary.BigEndian.Uint64(b) >> 1)
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+//rand returns the random source for the engine to use in response to the
+//latest event. It is derived from the game seed and event id, so replaying a
+//game with the same seed deals the same cards and roles every time.
+func (g Game) rand() *rand.Rand {
+	return rand.New(rand.NewSource(g.Seed ^ int64(g.EventID)*0x5DEECE66D))
+}
+
+func (gs Game) createNextRound() ([]Event, error) {
 	ge := GameEvent{}
-	if gs.Secret == "" {
-		ge.Game.Secret = genUUIDv4()
+	if gs.Secret == "" && len(gs.Keys) == 0 {
+		key, err := newTokenKey()
+		if err != nil {
+			return nil, err
+		}
+		ge.Game.Keys = []TokenKey{key}
 	}
 	ge.Type = TypeGameUpdate
 	ge.Game.State = GameStateStarted
@@ -82,7 +101,7 @@ func (gs Game) createNextRound() []Event {
 		BaseEvent: BaseEvent{Type: TypeRequestNominate},
 		PlayerID:  ge.Game.Round.PresidentID,
 		RoundID:   ge.Game.Round.ID,
-	}}
+	}}, nil
 }
 
 func executiveAction(numPlayers, numFascistPolicies int) string {
@@ -111,8 +130,17 @@ func executiveAction(numPlayers, numFascistPolicies int) string {
 
 //The engine will read the incoming event and process it to see if a new event
 // should be created to update the game state. This function itself should not modify the game
-// state in any way other than returning events that will.
+// state in any way other than returning events that will. Any requests it makes
+// are given a deadline according to the game timeouts.
 func (g Game) Engine(e Event) ([]Event, error) {
+	ret, err := g.engine(e)
+	if err != nil {
+		return ret, err
+	}
+	return g.Timeouts.withDeadlines(ret, time.Now()), nil
+}
+
+func (g Game) engine(e Event) ([]Event, error) {
 	ret := []Event{}
 
 	switch e.GetType() {
@@ -130,6 +158,12 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			ge := GameEvent{}
 			ge.Type = TypeGameUpdate
 			ge.Game.State = GameStateInit
+			//Pick a seed for the game if the admin didn't provide one
+			if g.Seed == 0 {
+				g.Seed = genSeed()
+				ge.Game.Seed = g.Seed
+			}
+			rng := g.rand()
 			ge.Game.Draw = make([]string, 0)
 			for i := 0; i < 11; i++ {
 				ge.Game.Draw = append(ge.Game.Draw, PolicyFascist)
@@ -137,7 +171,7 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			for i := 0; i < 6; i++ {
 				ge.Game.Draw = append(ge.Game.Draw, PolicyLiberal)
 			}
-			rand.Shuffle(len(ge.Game.Draw), func(i, j int) {
+			rng.Shuffle(len(ge.Game.Draw), func(i, j int) {
 				ge.Game.Draw[i], ge.Game.Draw[j] = ge.Game.Draw[j], ge.Game.Draw[i]
 			})
 			roles := []string{RoleLiberal, RoleLiberal, RoleLiberal, RoleHitler, RoleFascist}
@@ -156,7 +190,7 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			if len(g.Players) > 9 {
 				roles = append(roles, RoleLiberal)
 			}
-			rand.Shuffle(len(roles), func(i, j int) {
+			rng.Shuffle(len(roles), func(i, j int) {
 				roles[i], roles[j] = roles[j], roles[i]
 			})
 			for i, p := range g.Players {
@@ -168,7 +202,7 @@ func (g Game) Engine(e Event) ([]Event, error) {
 				}
 				ge.Game.Players = append(ge.Game.Players, p)
 			}
-			ge.Game.NextPresidentID = g.Players[rand.Intn(len(g.Players)-1)].ID
+			ge.Game.NextPresidentID = g.Players[rng.Intn(len(g.Players))].ID
 			ret = append(ret, ge, RequestEvent{
 				BaseEvent: BaseEvent{Type: TypeRequestAcknowledge},
 				PlayerID:  PlayerIDAll,
@@ -182,7 +216,11 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			}
 		}
 		if allAck {
-			ret = append(ret, g.createNextRound()...)
+			next, err := g.createNextRound()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, next...)
 		}
 	case TypePlayerNominate:
 		ret = append(ret, GameEvent{
@@ -268,19 +306,24 @@ func (g Game) Engine(e Event) ([]Event, error) {
 						},
 					},
 				})
+				token, err := g.token(Token{
+					EventID:     g.EventID,
+					Assertion:   TypeRequestLegislate,
+					PlayerID:    g.Round.PresidentID,
+					RoundID:     g.Round.ID,
+					PolicyCount: 3,
+					Policies:    g.Draw[len(g.Draw)-3:],
+				})
+				if err != nil {
+					return nil, err
+				}
 				ret = append(ret, RequestEvent{
 					BaseEvent:    BaseEvent{Type: TypeRequestLegislate},
 					PlayerID:     g.Round.PresidentID,
 					RoundID:      g.Round.ID,
 					Policies:     g.Draw[len(g.Draw)-3:],
 					VetoPossible: g.Fascist > 4,
-					Token: createToken(g.Secret, Token{
-						EventID:     g.EventID,
-						Assertion:   TypeRequestLegislate,
-						PlayerID:    g.Round.PresidentID,
-						RoundID:     g.Round.ID,
-						PolicyCount: 3,
-					}),
+					Token:        token,
 				})
 			} else {
 				//If the vote failed, enact a policy if failed votes = 3
@@ -305,9 +348,9 @@ func (g Game) Engine(e Event) ([]Event, error) {
 					ge.Game.Draw = g.Draw[:len(g.Draw)-1]
 					//Shuffle if there are < 3 policies in the draw pile
 					if len(ge.Game.Draw) < 3 {
-						ge.Game.Draw = append(ge.Game.Draw, g.Discard...)
+						ge.Game.Draw = appendPolicies(ge.Game.Draw, g.Discard...)
 						ge.Game.Discard = []string{"-"}
-						rand.Shuffle(len(ge.Game.Draw), func(i, j int) {
+						g.rand().Shuffle(len(ge.Game.Draw), func(i, j int) {
 							ge.Game.Draw[i], ge.Game.Draw[j] = ge.Game.Draw[j], ge.Game.Draw[i]
 						})
 					}
@@ -324,7 +367,11 @@ func (g Game) Engine(e Event) ([]Event, error) {
 					}
 					ret = append(ret, ge)
 					if !over {
-						ret = append(ret, g.createNextRound()...)
+						next, err := g.createNextRound()
+						if err != nil {
+							return nil, err
+						}
+						ret = append(ret, next...)
 					} else {
 						ret = append(ret, FinishedEvent{
 							BaseEvent:        BaseEvent{Type: TypeGameFinished},
@@ -340,12 +387,17 @@ func (g Game) Engine(e Event) ([]Event, error) {
 						},
 					})
 					//End the round now, start a new one
-					ret = append(ret, g.createNextRound()...)
+					next, err := g.createNextRound()
+					if err != nil {
+						return nil, err
+					}
+					ret = append(ret, next...)
 				}
 			}
 		}
 	case TypePlayerLegislate:
 		le := e.(PlayerLegislateEvent)
+		rng := g.rand()
 		ge := GameEvent{
 			BaseEvent: BaseEvent{Type: TypeGameUpdate},
 		}
@@ -373,7 +425,7 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			//First subtract the discarded policy from the round policies
 			ge.Game.Round.Policies = removeElement(g.Round.Policies, le.Discard)
 			//Second add it to the game discard pile
-			ge.Game.Discard = append(g.Discard, le.Discard)
+			ge.Game.Discard = appendPolicies(g.Discard, le.Discard)
 		} else {
 			ge.Game.Round.Policies = g.Round.Policies
 		}
@@ -384,7 +436,7 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			//Is this because it's the president responding to a veto?
 			if le.Veto {
 				//Discard the last remaining tile
-				ge.Game.Discard = append(g.Discard, ge.Game.Round.Policies[0])
+				ge.Game.Discard = appendPolicies(g.Discard, ge.Game.Round.Policies[0])
 				ge.Game.Round.Policies = []string{"-"}
 				ge.Game.ElectionTracker = g.ElectionTracker + 1
 			} else {
@@ -412,9 +464,9 @@ func (g Game) Engine(e Event) ([]Event, error) {
 			}
 			//Shuffle if there are < 3 policies in the draw pile
 			if len(g.Draw) < 3 {
-				ge.Game.Draw = append(g.Draw, ge.Game.Discard...)
+				ge.Game.Draw = appendPolicies(g.Draw, ge.Game.Discard...)
 				ge.Game.Discard = []string{"-"}
-				rand.Shuffle(len(ge.Game.Draw), func(i, j int) {
+				rng.Shuffle(len(ge.Game.Draw), func(i, j int) {
 					ge.Game.Draw[i], ge.Game.Draw[j] = ge.Game.Draw[j], ge.Game.Draw[i]
 				})
 			}
@@ -423,13 +475,21 @@ func (g Game) Engine(e Event) ([]Event, error) {
 				ge.Game.ElectionTracker = -1
 				ge.Game.PreviousPresidentID = "-"
 				ge.Game.PreviousChancellorID = "-"
-				var tp string
-				if len(ge.Game.Draw) > 0 {
-					tp = ge.Game.Draw[len(g.Draw)-1]
-					ge.Game.Draw = ge.Game.Draw[:len(ge.Game.Draw)-1]
-				} else {
-					tp = g.Draw[len(g.Draw)-1]
-					ge.Game.Draw = g.Draw[:len(g.Draw)-1]
+				//Flip from the draw pile as it stands after any shuffle above
+				draw := ge.Game.Draw
+				if len(draw) == 0 {
+					draw = g.Draw
+				}
+				tp := draw[len(draw)-1]
+				ge.Game.Draw = draw[:len(draw)-1]
+				//Shuffle again if the flip left < 3 policies in the draw pile, and
+				//the discard pile wasn't just shuffled in
+				if len(ge.Game.Draw) < 3 && ge.Game.Discard[0] != "-" {
+					ge.Game.Draw = appendPolicies(ge.Game.Draw, ge.Game.Discard...)
+					ge.Game.Discard = []string{"-"}
+					rng.Shuffle(len(ge.Game.Draw), func(i, j int) {
+						ge.Game.Draw[i], ge.Game.Draw[j] = ge.Game.Draw[j], ge.Game.Draw[i]
+					})
 				}
 				if tp == PolicyLiberal {
 					ge.Game.Liberal = g.Liberal + 1
@@ -478,20 +538,29 @@ func (g Game) Engine(e Event) ([]Event, error) {
 					} else {
 						pp = ge.Game.Draw[len(ge.Game.Draw)-3:]
 					}
+					token, err := g.token(Token{
+						PlayerID:    g.Round.PresidentID,
+						EventID:     g.EventID,
+						RoundID:     g.Round.ID,
+						Assertion:   ExecutiveActionPeek,
+						PolicyCount: 3,
+						Policies:    pp,
+					})
+					if err != nil {
+						return nil, err
+					}
 					ret = append(ret, InformationEvent{
 						BaseEvent: BaseEvent{Type: TypeGameInformation},
 						PlayerID:  g.Round.PresidentID,
 						RoundID:   g.Round.ID,
 						Policies:  pp,
-						Token: createToken(g.Secret, Token{
-							PlayerID:    g.Round.PresidentID,
-							EventID:     g.EventID,
-							RoundID:     g.Round.ID,
-							Assertion:   ExecutiveActionPeek,
-							PolicyCount: 3,
-						}),
+						Token:     token,
 					})
-					ret = append(ret, g.createNextRound()...)
+					next, err := g.createNextRound()
+					if err != nil {
+						return nil, err
+					}
+					ret = append(ret, next...)
 				case ExecutiveActionSpecialElection:
 					ret = append(ret, RequestEvent{
 						BaseEvent:       BaseEvent{Type: TypeRequestExecutiveAction},
@@ -508,27 +577,40 @@ func (g Game) Engine(e Event) ([]Event, error) {
 					})
 				default:
 					//If no exeutive action, start a new round
-					ret = append(ret, g.createNextRound()...)
+					next, err := g.createNextRound()
+					if err != nil {
+						return nil, err
+					}
+					ret = append(ret, next...)
 				}
 			} else {
-				ret = append(ret, g.createNextRound()...)
+				next, err := g.createNextRound()
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, next...)
 			}
 		}
 		if len(ge.Game.Round.Policies) > 1 {
 			//Trigger a legislate chancellor with the remaining cards
+			token, err := g.token(Token{
+				EventID:     g.EventID,
+				Assertion:   TypeRequestLegislate,
+				PlayerID:    g.Round.ChancellorID,
+				RoundID:     g.Round.ID,
+				PolicyCount: 2,
+				Policies:    ge.Game.Round.Policies,
+			})
+			if err != nil {
+				return nil, err
+			}
 			ret = append(ret, RequestEvent{
 				BaseEvent:    BaseEvent{Type: TypeRequestLegislate},
 				PlayerID:     g.Round.ChancellorID,
 				RoundID:      g.Round.ID,
 				Policies:     ge.Game.Round.Policies,
 				VetoPossible: g.Fascist > 4,
-				Token: createToken(g.Secret, Token{
-					EventID:     g.EventID,
-					Assertion:   TypeRequestLegislate,
-					PlayerID:    g.Round.ChancellorID,
-					RoundID:     g.Round.ID,
-					PolicyCount: 2,
-				}),
+				Token:        token,
 			})
 		}
 	case TypePlayerInvestigate:
@@ -540,23 +622,36 @@ func (g Game) Engine(e Event) ([]Event, error) {
 				party = p.Party
 			}
 		}
+		token, err := g.token(Token{
+			PlayerID:      g.Round.PresidentID,
+			OtherPlayerID: te.OtherPlayerID,
+			EventID:       g.EventID,
+			RoundID:       g.Round.ID,
+			Assertion:     ExecutiveActionInvestigate,
+			Party:         party,
+		})
+		if err != nil {
+			return nil, err
+		}
 		ret = append(ret, InformationEvent{
 			BaseEvent:     BaseEvent{Type: TypeGameInformation},
 			PlayerID:      g.Round.PresidentID,
 			OtherPlayerID: te.OtherPlayerID,
 			RoundID:       g.Round.ID,
 			Party:         party,
-			Token: createToken(g.Secret, Token{
-				PlayerID:      g.Round.PresidentID,
-				OtherPlayerID: te.OtherPlayerID,
-				EventID:       g.EventID,
-				RoundID:       g.Round.ID,
-				Assertion:     ExecutiveActionInvestigate,
-			}),
+			Token:         token,
 		})
-		ret = append(ret, g.createNextRound()...)
+		next, err := g.createNextRound()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, next...)
 	case TypePlayerSpecialElection:
-		ret = append(ret, g.createNextRound()...)
+		next, err := g.createNextRound()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, next...)
 	case TypePlayerExecute:
 		//If hitler is assasinated, game over for fascists
 		for _, p := range g.Players {
@@ -575,7 +670,11 @@ func (g Game) Engine(e Event) ([]Event, error) {
 				return ret, nil
 			}
 		}
-		ret = append(ret, g.createNextRound()...)
+		next, err := g.createNextRound()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, next...)
 	}
 	return ret, nil
 }
@@ -588,11 +687,22 @@ func removeElement(a []string, e string) []string {
 			break
 		}
 	}
-	if i >= 0 {
-		a[i] = a[len(a)-1]
-		a = a[:len(a)-1]
+	if i < 0 {
+		return a
 	}
-	return a
+	//Leave the original alone, it is still part of the game state
+	ret := appendPolicies(nil, a...)
+	ret[i] = ret[len(ret)-1]
+	return ret[:len(ret)-1]
+}
+
+//appendPolicies returns a new slice with the policies added to the end of a.
+//The engine mustn't append to the game state directly, as the slices are shared
+//with the current game and the events that built it.
+func appendPolicies(a []string, policies ...string) []string {
+	ret := make([]string, 0, len(a)+len(policies))
+	ret = append(ret, a...)
+	return append(ret, policies...)
 }
 
 func removeAtIndex(s []string, i int) []string {
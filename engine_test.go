@@ -1,7 +1,9 @@
 package sh
 
 import (
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGameStart(t *testing.T) {
@@ -101,3 +103,193 @@ func TestVeto(t *testing.T) {
 		t.Log(e)
 	}
 }
+
+func TestGameStartSeeded(t *testing.T) {
+	deal := func(seed int64) GameEvent {
+		g := Game{Seed: seed, EventID: 10}
+		for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+			g.Players = append(g.Players, Player{ID: id, Ready: true})
+		}
+		events, err := g.Engine(PlayerEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerReady},
+			Player:    Player{ID: "7", Ready: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events[0].(GameEvent)
+	}
+	a, b := deal(42), deal(42)
+	if a.Game.Seed != 0 {
+		t.Fatal("Engine should not replace a provided seed")
+	}
+	if !reflect.DeepEqual(a.Game.Draw, b.Game.Draw) || !reflect.DeepEqual(a.Game.Players, b.Game.Players) || a.Game.NextPresidentID != b.Game.NextPresidentID {
+		t.Fatal("Expected the same seed to deal the same game")
+	}
+	c := deal(43)
+	if reflect.DeepEqual(a.Game.Draw, c.Game.Draw) && reflect.DeepEqual(a.Game.Players, c.Game.Players) {
+		t.Fatal("Expected a different seed to deal a different game")
+	}
+	//Without a seed the engine should pick one and record it
+	if d := deal(0); d.Game.Seed == 0 {
+		t.Fatal("Expected the engine to record the seed it picked")
+	}
+}
+
+func TestVetoFlip(t *testing.T) {
+	veto := func(seed int64, draw []string) GameEvent {
+		g := Game{
+			Seed:            seed,
+			State:           GameStateStarted,
+			Players:         votingGame().Players,
+			NextPresidentID: "2",
+			ElectionTracker: 2,
+			Draw:            draw,
+			Discard:         []string{PolicyFascist, PolicyFascist},
+			Round: Round{
+				ID:           4,
+				PresidentID:  "1",
+				ChancellorID: "2",
+				Policies:     []string{PolicyFascist},
+				State:        RoundStateLegislating,
+			},
+		}
+		events, err := g.Engine(PlayerLegislateEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerLegislate},
+			PlayerID:  "1",
+			Veto:      true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			if ge, ok := e.(GameEvent); ok {
+				return ge
+			}
+		}
+		t.Fatal("No Game update event")
+		return GameEvent{}
+	}
+	//The draw pile is short, so the flip has to come from the top of the pile
+	//after the discards are shuffled in. The one liberal policy is either
+	//flipped or still in the pile.
+	for seed := int64(1); seed <= 20; seed++ {
+		ge := veto(seed, []string{PolicyLiberal})
+		if ge.Game.ElectionTracker != -1 || ge.Game.PreviousEnactedPolicy == "" {
+			t.Fatal("Expected the top policy to be flipped", ge.Game)
+		}
+		liberal := ge.Game.Liberal
+		for _, p := range ge.Game.Draw {
+			if p == PolicyLiberal {
+				liberal++
+			}
+		}
+		if liberal != 1 || len(ge.Game.Draw) != 3 {
+			t.Fatal("Expected the flipped policy to leave the draw pile", seed, ge.Game)
+		}
+		if len(ge.Game.Discard) != 1 || ge.Game.Discard[0] != "-" {
+			t.Fatal("Expected the discard pile to be shuffled in", seed, ge.Game.Discard)
+		}
+	}
+	//A full draw pile flips its top policy, and shuffles the discards in if
+	//that leaves less than three
+	ge := veto(1, []string{PolicyFascist, PolicyFascist, PolicyLiberal})
+	if ge.Game.Liberal != 1 || ge.Game.PreviousEnactedPolicy != PolicyLiberal {
+		t.Fatal("Expected the top policy to be flipped", ge.Game)
+	}
+	if len(ge.Game.Draw) != 5 || len(ge.Game.Discard) != 1 || ge.Game.Discard[0] != "-" {
+		t.Fatal("Expected the discard pile to be shuffled in after the flip", ge.Game.Draw, ge.Game.Discard)
+	}
+}
+
+func TestEngineLeavesGameAlone(t *testing.T) {
+	g := votingGame()
+	g.Round.State = RoundStateLegislating
+	//Spare capacity is where an append would write over the game
+	g.Round.Policies = append(make([]string, 0, 10), PolicyLiberal, PolicyFascist, PolicyFascist)
+	g.Discard = append(make([]string, 0, 10), PolicyFascist)
+	_, err := g.Engine(PlayerLegislateEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerLegislate},
+		PlayerID:  "1",
+		Discard:   PolicyLiberal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g.Round.Policies, []string{PolicyLiberal, PolicyFascist, PolicyFascist}) {
+		t.Fatal("Expected the round policies to be left alone", g.Round.Policies)
+	}
+	if spare := g.Discard[:2]; spare[1] != "" {
+		t.Fatal("Expected the discard pile to be left alone", spare)
+	}
+
+	//Applying a vote mustn't change the votes or players of the old state
+	g = votingGame()
+	g.Round.Votes = append(make([]Vote, 0, 10), g.Round.Votes...)
+	ng, _, err := g.Apply(PlayerVoteEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerVote},
+		PlayerID:  "3",
+		Vote:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ng.Round.Votes) != 3 {
+		t.Fatal("Expected the vote to be applied", ng.Round.Votes)
+	}
+	if spare := g.Round.Votes[:3]; spare[2].PlayerID != "" {
+		t.Fatal("Expected the old votes to be left alone", spare)
+	}
+}
+
+func TestEngineOneVoteResult(t *testing.T) {
+	sh := NewSecretHitler()
+	sh.Game = votingGame()
+	sh.Game.NextPresidentID = "2"
+	for i := 0; i < 17; i++ {
+		sh.Game.Draw = append(sh.Game.Draw, PolicyFascist)
+	}
+	c := make(chan Event, 100)
+	sh.AddSubscriber("results", c)
+	//The last votes go in before the engine gets to any of them, as they do
+	//when players vote at the same time
+	sh.m.Lock()
+	for _, id := range []string{"3", "4", "5"} {
+		g, ne, err := sh.Game.Apply(PlayerVoteEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerVote},
+			PlayerID:  id,
+			Vote:      true,
+		})
+		if err != nil {
+			sh.m.Unlock()
+			t.Fatal(err)
+		}
+		sh.Game = g
+		sh.queueEngine(ne, g)
+	}
+	sh.m.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sh.m.RLock()
+		next := sh.Game.Round.State != RoundStateVoting
+		sh.m.RUnlock()
+		if next {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the votes to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	sh.Close()
+	results := 0
+	for len(c) > 0 {
+		if vr, ok := (<-c).(VoteResultEvent); ok && vr.RoundID == 3 {
+			results++
+		}
+	}
+	if results != 1 {
+		t.Fatal("Expected one vote result for the round", results)
+	}
+}
@@ -38,11 +38,14 @@ const (
 	TypeGameInformation = "game.information"
 	TypeGameUpdate      = "game.update"
 	TypeGameFinished    = "game.finished"
+	TypeGameTimeout     = "game.timeout"
+	TypeGameError       = "game.error"
 )
 
 type Event interface {
 	GetID() int
 	GetType() string
+	GetMoment() time.Time
 	Filter(context.Context) Event
 }
 
@@ -199,6 +202,16 @@ func UnmarshalEvent(b []byte) (Event, error) {
 			e.Moment = time.Now()
 		}
 		return e, nil
+	case TypeGameTimeout:
+		e := TimeoutEvent{}
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return bt, err
+		}
+		if e.Moment.IsZero() {
+			e.Moment = time.Now()
+		}
+		return e, nil
 	case TypeGameUpdate:
 		e := GameEvent{}
 		err = json.Unmarshal(b, &e)
@@ -209,6 +222,16 @@ func UnmarshalEvent(b []byte) (Event, error) {
 			e.Moment = time.Now()
 		}
 		return e, nil
+	case TypeGameError:
+		e := ErrorEvent{}
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return bt, err
+		}
+		if e.Moment.IsZero() {
+			e.Moment = time.Now()
+		}
+		return e, nil
 	default:
 		return bt, errors.New("Unknown Event Type")
 	}
@@ -222,6 +245,7 @@ type BaseEvent struct {
 
 func (e BaseEvent) GetID() int                       { return e.ID }
 func (e BaseEvent) GetType() string                  { return e.Type }
+func (e BaseEvent) GetMoment() time.Time             { return e.Moment }
 func (e BaseEvent) Filter(ctx context.Context) Event { return e }
 
 type PlayerEvent struct {
@@ -230,8 +254,8 @@ type PlayerEvent struct {
 }
 
 func (e PlayerEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" && pid != e.Player.ID {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.Player.ID) {
 		if e.Player.Party != "" {
 			e.Player.Party = PartyMasked
 		}
@@ -257,8 +281,8 @@ type PlayerVoteEvent struct {
 }
 
 func (e PlayerVoteEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerId").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.PlayerID) {
 		e.Vote = false
 	}
 	return e
@@ -272,8 +296,8 @@ type PlayerLegislateEvent struct {
 }
 
 func (e PlayerLegislateEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerId").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.PlayerID) {
 		e.Discard = PolicyMasked
 		e.Veto = false
 	}
@@ -303,10 +327,7 @@ type GameEvent struct {
 }
 
 func (e GameEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" {
-		e.Game = e.Game.Filter(ctx)
-	}
+	e.Game = e.Game.Filter(ctx)
 	return e
 }
 
@@ -321,8 +342,8 @@ type InformationEvent struct {
 }
 
 func (e InformationEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.PlayerID) {
 		if e.Policies != nil {
 			np := []string{}
 			for range e.Policies {
@@ -331,6 +352,9 @@ func (e InformationEvent) Filter(ctx context.Context) Event {
 			e.Policies = np
 		}
 		e.Party = PartyMasked
+		if e.Token != "" {
+			e.Token = "masked"
+		}
 	}
 	return e
 }
@@ -347,20 +371,21 @@ func (e FinishedEvent) Filter(ctx context.Context) Event {
 
 type RequestEvent struct {
 	BaseEvent
-	PlayerID        string   `json:"playerId"`
-	RoundID         int      `json:"roundId"`
-	PresidentID     string   `json:"presidentId,omitempty"`
-	ChancellorID    string   `json:"chancellorId,omitempty"`
-	ExecutiveAction string   `json:"executiveAction,omitempty"`
-	Policies        []string `json:"policies,omitempty"`
-	VetoPossible    bool     `json:"vetoPossible,omitempty"`
-	Veto            bool     `json:"veto,omitempty"`
-	Token           string   `json:"token,omitempty"`
+	PlayerID        string    `json:"playerId"`
+	RoundID         int       `json:"roundId"`
+	PresidentID     string    `json:"presidentId,omitempty"`
+	ChancellorID    string    `json:"chancellorId,omitempty"`
+	ExecutiveAction string    `json:"executiveAction,omitempty"`
+	Policies        []string  `json:"policies,omitempty"`
+	VetoPossible    bool      `json:"vetoPossible,omitempty"`
+	Veto            bool      `json:"veto,omitempty"`
+	Token           string    `json:"token,omitempty"`
+	Deadline        time.Time `json:"deadline,omitempty"`
 }
 
 func (e RequestEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID && pid != "all" {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.PlayerID) && e.PlayerID != PlayerIDAll {
 		if e.Policies != nil {
 			np := []string{}
 			for range e.Policies {
@@ -368,6 +393,42 @@ func (e RequestEvent) Filter(ctx context.Context) Event {
 			}
 			e.Policies = np
 		}
+		if e.Token != "" {
+			e.Token = "masked"
+		}
+	}
+	return e
+}
+
+//TimeoutEvent is sent by the engine when a player failed to respond to a request
+//before its deadline. It is followed by the default action taken for them.
+type TimeoutEvent struct {
+	BaseEvent
+	PlayerID    string `json:"playerId"`
+	RoundID     int    `json:"roundId"`
+	RequestType string `json:"requestType"`
+}
+
+func (e TimeoutEvent) Filter(ctx context.Context) Event { return e }
+
+//ErrorEvent reports that the engine failed to respond to an event, or that the
+//game refused an event the engine generated, so an operator can see a game that
+//is stuck. It carries the id of the latest event, and is only sent to admin
+//subscribers. It is never applied to the game or logged.
+type ErrorEvent struct {
+	BaseEvent
+	//EventID and EventType are the event the engine was responding to
+	EventID   int    `json:"eventId"`
+	EventType string `json:"eventType"`
+	//RefusedType is the type of the event the game refused, if any
+	RefusedType string `json:"refusedType,omitempty"`
+	PlayerID    string `json:"playerId,omitempty"`
+	Error       string `json:"error"`
+}
+
+func (e ErrorEvent) Filter(ctx context.Context) Event {
+	if !ViewerFrom(ctx).Trusted() {
+		e.Error = "masked"
 	}
 	return e
 }
@@ -391,15 +452,16 @@ type AssertEvent struct {
 	Policies      []string `json:"policies,omitempty"`
 	OtherPlayerID string   `json:"otherPlayerId,omitempty"`
 	Party         string   `json:"party,omitempty"`
+	//Verified is set by the engine, in a game with honest claims, when the
+	//claim matches what its token shows. A claim without a token is free talk,
+	//and never verified.
+	Verified bool `json:"verified"`
 }
 
-func (e AssertEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID {
-		e.Token = "masked"
-	}
-	return e
-}
+//Filter shows the assertion to everyone as it was made. A player attaching a
+//token to a claim is presenting it, for the other players to check against the
+//public keys of the game.
+func (e AssertEvent) Filter(ctx context.Context) Event { return e }
 
 // GuessEvent is an event a player can send to make a prediction or guess as to outcomes of the game
 type GuessEvent struct {
@@ -412,8 +474,8 @@ type GuessEvent struct {
 }
 
 func (e GuessEvent) Filter(ctx context.Context) Event {
-	pid, _ := ctx.Value("playerID").(string)
-	if pid != "admin" && pid != "engine" && pid != e.PlayerID {
+	v := ViewerFrom(ctx)
+	if !v.SeesAll() && !v.Is(e.PlayerID) {
 		e.PlayerID = "masked"
 		nf := []string{}
 		for _, _ = range e.FascistIDs {
@@ -0,0 +1,319 @@
+package sh
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//Backpressure sets what the game does with a subscriber that has fallen so far
+//behind that its queue is full
+type Backpressure int
+
+const (
+	//BackpressureDisconnect disconnects the subscriber, see Disconnected
+	BackpressureDisconnect Backpressure = iota
+	//BackpressureDropOldest drops the oldest queued event to make room
+	BackpressureDropOldest
+	//BackpressureBlock waits for room for up to the BlockTimeout, and then
+	//disconnects the subscriber. The game is held up while it waits.
+	BackpressureBlock
+)
+
+//DefaultQueueSize is the queue size of a subscriber that doesn't set one
+const DefaultQueueSize = 64
+
+//DefaultBlockTimeout is the BlockTimeout of a subscriber that doesn't set one
+var DefaultBlockTimeout = time.Second
+
+//SubscriberOptions set how events are queued for a subscriber
+type SubscriberOptions struct {
+	//QueueSize is the number of events that can wait on the subscriber
+	QueueSize    int
+	Backpressure Backpressure
+	//BlockTimeout is how long BackpressureBlock waits for room, zero for the
+	//DefaultBlockTimeout. It never waits for as long as it takes, as the game
+	//is locked while it waits, and a subscriber that submits events from the
+	//loop reading its channel would never make room.
+	BlockTimeout time.Duration
+	//Admin subscribers are also sent the ErrorEvents of the game
+	Admin bool
+}
+
+//SubscriberStats are the delivery metrics of a subscriber
+type SubscriberStats struct {
+	Key       string `json:"key"`
+	Queued    int    `json:"queued"`
+	Delivered int    `json:"delivered"`
+	Dropped   int    `json:"dropped"`
+	//Lag is how many events the subscriber is behind the latest broadcast
+	Lag int `json:"lag"`
+	//Disconnected is set once the subscriber fell too far behind. It is sent
+	//nothing more, and is listed until it is removed.
+	Disconnected bool `json:"disconnected,omitempty"`
+}
+
+//subscriber queues the broadcast events for one channel. Its pump is the only
+//sender on the channel, so the events arrive in the order they were queued.
+type subscriber struct {
+	c    chan<- Event
+	opts SubscriberOptions
+	//filter, if set, is applied to every event as it is sent
+	filter func(Event) Event
+	//owned is set when the game made the channel, and closes it once the
+	//subscriber is stopped. Any other channel is left open.
+	owned bool
+
+	m sync.Mutex
+	//backlog are events from before the subscriber was added, sent ahead of
+	//the queue without counting against its size
+	backlog      []Event
+	queue        []Event
+	delivered    int
+	dropped      int
+	lastID       int
+	disconnected bool
+	wake         chan struct{}
+	room         chan struct{}
+	stop         chan struct{}
+	//gone is closed once the subscriber is disconnected
+	gone chan struct{}
+	once sync.Once
+}
+
+//newSubscriber returns a subscriber that is sent the events after lastID,
+//which starts sending once it is added
+func newSubscriber(c chan<- Event, opts SubscriberOptions, lastID int) *subscriber {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BlockTimeout <= 0 {
+		opts.BlockTimeout = DefaultBlockTimeout
+	}
+	return &subscriber{
+		c:      c,
+		opts:   opts,
+		lastID: lastID,
+		wake:   make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		gone:   make(chan struct{}),
+	}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+//push queues the event, applying the backpressure policy when the queue is
+//full. It returns false when the subscriber has to be disconnected.
+func (s *subscriber) push(e Event) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.queue) >= s.opts.QueueSize {
+		switch s.opts.Backpressure {
+		case BackpressureDropOldest:
+			s.queue = s.queue[1:]
+			s.dropped++
+		case BackpressureBlock:
+			t := time.NewTimer(s.opts.BlockTimeout)
+			defer t.Stop()
+			for len(s.queue) >= s.opts.QueueSize {
+				s.m.Unlock()
+				select {
+				case <-s.room:
+				case <-t.C:
+					s.m.Lock()
+					return false
+				case <-s.stop:
+					s.m.Lock()
+					return true
+				}
+				s.m.Lock()
+			}
+		default:
+			return false
+		}
+	}
+	s.queue = append(s.queue, e)
+	signal(s.wake)
+	return true
+}
+
+//pump sends the queued events to the channel until the subscriber is stopped.
+//The channel is closed if the game owns it.
+func (s *subscriber) pump() {
+	defer func() {
+		if s.owned {
+			close(s.c)
+		}
+	}()
+	for {
+		s.m.Lock()
+		var e Event
+		if len(s.backlog) > 0 {
+			e = s.backlog[0]
+			s.backlog = s.backlog[1:]
+			s.m.Unlock()
+		} else if len(s.queue) > 0 {
+			e = s.queue[0]
+			s.queue = s.queue[1:]
+			s.m.Unlock()
+			signal(s.room)
+		} else {
+			s.m.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+		out := e
+		if s.filter != nil {
+			out = s.filter(e)
+		}
+		select {
+		case s.c <- out:
+			s.m.Lock()
+			s.delivered++
+			s.lastID = e.GetID()
+			s.m.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+//close stops the pump, and marks the subscriber disconnected if disconnect is
+//set
+func (s *subscriber) close(disconnect bool) {
+	s.once.Do(func() {
+		s.m.Lock()
+		s.disconnected = disconnect
+		s.m.Unlock()
+		if disconnect {
+			close(s.gone)
+		}
+		close(s.stop)
+	})
+}
+
+func (s *subscriber) isDisconnected() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.disconnected
+}
+
+func (s *subscriber) stats(key string, latest int) SubscriberStats {
+	s.m.Lock()
+	defer s.m.Unlock()
+	lag := latest - s.lastID
+	if lag < 0 {
+		lag = 0
+	}
+	return SubscriberStats{
+		Key:          key,
+		Queued:       len(s.backlog) + len(s.queue),
+		Delivered:    s.delivered,
+		Dropped:      s.dropped,
+		Lag:          lag,
+		Disconnected: s.disconnected,
+	}
+}
+
+//addSubscriber queues the events broadcast from now on for the channel. The
+//caller must hold the game lock.
+func (sh *SecretHitler) addSubscriber(key string, c chan<- Event, opts SubscriberOptions) {
+	sh.add(key, newSubscriber(c, opts, sh.Game.EventID))
+}
+
+//add starts the subscriber and queues the events broadcast from now on for it.
+//Any backlog it has must hold the events up to the current one. The caller
+//must hold the game lock.
+func (sh *SecretHitler) add(key string, s *subscriber) {
+	sh.subM.Lock()
+	defer sh.subM.Unlock()
+	if sh.subsClosed {
+		//The game is closed, so a subscription ends right away
+		if s.owned {
+			close(s.c)
+		}
+		return
+	}
+	sh.addLocked(key, s)
+}
+
+//addLocked is add for a caller holding the subscriber lock of an open game
+func (sh *SecretHitler) addLocked(key string, s *subscriber) {
+	if old, ok := sh.subscribers[key]; ok {
+		old.close(false)
+	}
+	sh.subscribers[key] = s
+	sh.spawn(s.pump)
+}
+
+//spawn runs f on a goroutine that Close waits for. The caller must hold the
+//subscriber lock of an open game.
+func (sh *SecretHitler) spawn(f func()) {
+	sh.workers.Add(1)
+	go func() {
+		defer sh.workers.Done()
+		f()
+	}()
+}
+
+//broadcast queues the event for every subscriber, or only the admins for an
+//ErrorEvent. SubmitEvent calls it while holding the game lock, so every
+//subscriber gets the events in order.
+func (sh *SecretHitler) broadcast(e Event) {
+	_, adminOnly := e.(ErrorEvent)
+	sh.subM.Lock()
+	if e.GetID() > sh.broadcastID {
+		sh.broadcastID = e.GetID()
+	}
+	subs := make([]*subscriber, 0, len(sh.subscribers))
+	for _, s := range sh.subscribers {
+		if (adminOnly && !s.opts.Admin) || s.isDisconnected() {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	sh.subM.Unlock()
+	for _, s := range subs {
+		if !s.push(e) {
+			//A subscriber that fell too far behind stays listed until it is
+			//removed, so its owner can tell why it stopped
+			s.close(true)
+		}
+	}
+}
+
+//Disconnected returns a channel that is closed once the subscriber with the key
+//falls too far behind and is disconnected, or nil if there is no such
+//subscriber. The channel given to AddSubscriber is left open, so this is how
+//its owner learns that nothing more will be sent on it.
+func (sh *SecretHitler) Disconnected(key string) <-chan struct{} {
+	sh.subM.Lock()
+	defer sh.subM.Unlock()
+	if s, ok := sh.subscribers[key]; ok {
+		return s.gone
+	}
+	return nil
+}
+
+//SubscriberStats returns the delivery metrics of every subscriber, ordered by
+//their keys
+func (sh *SecretHitler) SubscriberStats() []SubscriberStats {
+	sh.subM.Lock()
+	defer sh.subM.Unlock()
+	ret := []SubscriberStats{}
+	for k, s := range sh.subscribers {
+		ret = append(ret, s.stats(k, sh.broadcastID))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
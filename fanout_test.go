@@ -0,0 +1,178 @@
+package sh
+
+import (
+	"testing"
+	"time"
+)
+
+//broadcastN broadcasts events with ids 1 to n
+func broadcastN(sh *SecretHitler, n int) {
+	for i := 1; i <= n; i++ {
+		sh.BroadcastEvent(BaseEvent{ID: i, Type: TypeGameUpdate})
+	}
+}
+
+func TestFanoutInOrder(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	c := make(chan Event)
+	sh.AddSubscriber("slow", c)
+	done := make(chan struct{})
+	go func() {
+		broadcastN(sh, 50)
+		close(done)
+	}()
+	for i := 1; i <= 50; i++ {
+		select {
+		case e := <-c:
+			if e.GetID() != i {
+				t.Fatal("Expected the events in order", i, e.GetID())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event", i)
+		}
+	}
+	<-done
+}
+
+func TestFanoutDropOldest(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	c := make(chan Event)
+	sh.AddSubscriberWithOptions("slow", c, SubscriberOptions{QueueSize: 3, Backpressure: BackpressureDropOldest})
+	//Nobody is reading, so the broadcasts must not wait on the subscriber
+	broadcastN(sh, 10)
+	stats := sh.SubscriberStats()
+	if len(stats) != 1 || stats[0].Lag != 10 || stats[0].Queued > 3 || stats[0].Dropped < 6 {
+		t.Fatal("Expected the lag and drops to be counted", stats)
+	}
+	//The newest events are kept, still in order
+	last := 0
+	for last != 10 {
+		select {
+		case e := <-c:
+			if e.GetID() <= last {
+				t.Fatal("Expected the kept events in order", last, e.GetID())
+			}
+			last = e.GetID()
+		case <-time.After(time.Second):
+			t.Fatal("Expected the newest event to be kept", last)
+		}
+	}
+}
+
+func TestFanoutDisconnect(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	slow := make(chan Event)
+	fast := make(chan Event, 100)
+	sh.AddSubscriberWithOptions("slow", slow, SubscriberOptions{QueueSize: 2})
+	sh.AddSubscriber("fast", fast)
+	broadcastN(sh, 10)
+	deadline := time.Now().Add(time.Second)
+	for len(fast) != 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the other subscriber to get every event", len(fast))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-sh.Disconnected("slow"):
+	case <-time.After(time.Second):
+		t.Fatal("Expected the slow subscriber to be disconnected")
+	}
+	stats := sh.SubscriberStats()
+	if len(stats) != 2 || stats[0].Key != "fast" || stats[0].Disconnected || stats[1].Key != "slow" || !stats[1].Disconnected {
+		t.Fatal("Expected the slow subscriber to be listed as disconnected", stats)
+	}
+	//Whatever was queued is dropped, and the channel is the caller's, so it is
+	//left open
+	select {
+	case e := <-slow:
+		t.Fatal("Expected nothing sent once disconnected", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	sh.RemoveSubscriber("slow")
+	if stats := sh.SubscriberStats(); len(stats) != 1 || sh.Disconnected("slow") != nil {
+		t.Fatal("Expected the slow subscriber to be removed", stats)
+	}
+}
+
+func TestFanoutBlock(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	c := make(chan Event)
+	sh.AddSubscriberWithOptions("slow", c, SubscriberOptions{QueueSize: 2, Backpressure: BackpressureBlock, BlockTimeout: time.Second})
+	//A reader that keeps up, if slowly, gets everything
+	go func() {
+		for range c {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	broadcastN(sh, 20)
+	if stats := sh.SubscriberStats(); len(stats) != 1 || stats[0].Dropped != 0 {
+		t.Fatal("Expected the blocking subscriber to keep up", stats)
+	}
+
+	stuck := make(chan Event)
+	sh.AddSubscriberWithOptions("stuck", stuck, SubscriberOptions{QueueSize: 2, Backpressure: BackpressureBlock, BlockTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	broadcastN(sh, 5)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("Expected the broadcast to wait on the subscriber")
+	}
+	for _, s := range sh.SubscriberStats() {
+		if s.Key == "stuck" && !s.Disconnected {
+			t.Fatal("Expected the stuck subscriber to be disconnected after the timeout")
+		}
+	}
+}
+
+func TestFanoutBlockSubmitting(t *testing.T) {
+	defer func(d time.Duration) { DefaultBlockTimeout = d }(DefaultBlockTimeout)
+	DefaultBlockTimeout = 50 * time.Millisecond
+	sh := NewSecretHitler()
+	defer sh.Close()
+	//A subscriber that submits from the loop reading its channel can't make
+	//room while it waits on the game, so the game can't wait on it forever
+	c := make(chan Event)
+	sh.AddSubscriberWithOptions("bot", c, SubscriberOptions{QueueSize: 1, Backpressure: BackpressureBlock})
+	submitted := make(chan error)
+	go func() {
+		<-c
+		submitted <- join(sh, "1")
+	}()
+	broadcastN(sh, 5)
+	select {
+	case err := <-submitted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the game not to deadlock on the subscriber")
+	}
+	select {
+	case <-sh.Disconnected("bot"):
+	default:
+		t.Fatal("Expected the subscriber to be disconnected")
+	}
+}
+
+func TestRemoveSubscriberDoesNotWait(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	c := make(chan Event)
+	sh.AddSubscriberWithOptions("stuck", c, SubscriberOptions{Backpressure: BackpressureBlock})
+	broadcastN(sh, DefaultQueueSize+1)
+	removed := make(chan struct{})
+	go func() {
+		broadcastN(sh, 1)
+		close(removed)
+	}()
+	sh.RemoveSubscriber("stuck")
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected removing the subscriber to free the blocked broadcast")
+	}
+}
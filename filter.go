@@ -5,17 +5,34 @@ import (
 )
 
 func (g Game) Filter(ctx context.Context) Game {
-	playerID, _ := ctx.Value("playerID").(string)
-	if playerID == "admin" || g.State == GameStateFinished {
+	v := ViewerFrom(ctx)
+	if v.Trusted() {
 		return g
 	}
-	me, _ := g.GetPlayerByID(playerID)
-	//Filter the game secret
-	if g.Secret != "" {
-		g.Secret = "masked"
+	//Only the engine signs tokens, so the secrets of the game and its token
+	//keys are filtered from everyone else, even the omniscient spectators and
+	//once the game is over. They are cleared rather than masked, as anything
+	//put in their place would be a secret anyone could sign tokens with.
+	g.Secret = ""
+	g.Keys = publicKeys(g.Keys)
+	if v.SeesAll() || g.State == GameStateFinished {
+		//The seed would give away the shuffles still to come
+		if g.State != GameStateFinished {
+			g.Seed = 0
+		}
+		return g
+	}
+	//Spectators, and anyone else that isn't playing, get the public view
+	me := Player{}
+	if v.Kind == ViewerPlayer {
+		me, _ = g.GetPlayerByID(v.PlayerID)
 	}
+	isPresident := me.ID != "" && me.ID == g.Round.PresidentID
+	isChancellor := me.ID != "" && me.ID == g.Round.ChancellorID
+	//Filter the seed, it would give away the deal
+	g.Seed = 0
 	//Filter the draw and dscard pile
-	if g.PreviousPresidentID == playerID && g.Fascist == 3 && len(g.Players) < 7 && g.PreviousEnactedPolicy == PolicyFascist {
+	if me.ID != "" && g.PreviousPresidentID == me.ID && g.Fascist == 3 && len(g.Players) < 7 && g.PreviousEnactedPolicy == PolicyFascist {
 		g.Draw = maskedPolicies(g.Draw, true)
 	} else {
 		g.Draw = maskedPolicies(g.Draw, false)
@@ -34,7 +51,7 @@ func (g Game) Filter(ctx context.Context) Game {
 			InvestigatedBy: p.InvestigatedBy,
 			ExecutedBy:     p.ExecutedBy,
 		}
-		if me.ID == p.ID {
+		if me.ID != "" && me.ID == p.ID {
 			np.Party = p.Party
 			np.Role = p.Role
 		}
@@ -52,7 +69,7 @@ func (g Game) Filter(ctx context.Context) Game {
 	if g.Round.State == RoundStateVoting {
 		vs := make([]Vote, len(g.Round.Votes))
 		for i, v := range g.Round.Votes {
-			if me.ID == v.PlayerID {
+			if me.ID != "" && me.ID == v.PlayerID {
 				vs[i] = v
 			} else {
 				vs[i].PlayerID = v.PlayerID
@@ -62,19 +79,35 @@ func (g Game) Filter(ctx context.Context) Game {
 		g.Round.Votes = vs
 	}
 	//Filter the round policies
-	if me.ID != g.Round.PresidentID && me.ID != g.Round.ChancellorID {
+	if !isPresident && !isChancellor {
 		g.Round.Policies = maskedPolicies(g.Round.Policies, false)
-	} else if me.ID == g.Round.ChancellorID && len(g.Round.Policies) > 2 {
+	} else if isChancellor && len(g.Round.Policies) > 2 {
 		g.Round.Policies = maskedPolicies(g.Round.Policies, false)
 	}
 
 	return g
 }
 
+//publicKeys are the token keys with only their ids and public halves
+func publicKeys(keys []TokenKey) []TokenKey {
+	if keys == nil {
+		return nil
+	}
+	ret := make([]TokenKey, len(keys))
+	for i, k := range keys {
+		ret[i] = TokenKey{ID: k.ID, Public: k.Public}
+	}
+	return ret
+}
+
 func maskedPolicies(policies []string, exceptlast3 bool) []string {
 	ret := make([]string, len(policies))
 	for i := 0; i < len(policies); i++ {
 		ret[i] = PolicyMasked
+		//The placeholder that resets the policies in a delta hides nothing
+		if policies[i] == "-" {
+			ret[i] = "-"
+		}
 		if exceptlast3 && i > len(policies)-4 {
 			ret[i] = policies[i]
 		}
@@ -2,11 +2,12 @@ package sh
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +15,12 @@ const (
 	PlayerIDEngine = "engine"
 	PlayerIDAdmin  = "admin"
 	PlayerIDAll    = "all"
+	//PlayerIDSpectator is the public spectator view, it sees only what every
+	//player can see
+	PlayerIDSpectator = "spectator"
+	//PlayerIDOmniscient is the streamer view, it sees everything and should
+	//only be shown through a delayed spectator subscription
+	PlayerIDOmniscient = "omniscient"
 
 	PolicyFascist = "fascist"
 	PolicyLiberal = "liberal"
@@ -50,60 +57,370 @@ const (
 	ConditionPoliciesEnacted  = "policies_enacted"
 )
 
+//IsReservedID is true for the IDs that stand for the engine, the admin or a
+//spectator view, which no player can take
+func IsReservedID(id string) bool {
+	switch id {
+	case PlayerIDEngine, PlayerIDAdmin, PlayerIDAll, PlayerIDSpectator, PlayerIDOmniscient:
+		return true
+	}
+	return false
+}
+
 func NewSecretHitler() *SecretHitler {
 	ret := new(SecretHitler)
-	ret.subscribers = make(map[string]chan<- Event)
-	ec := make(chan Event, 10)
-	//Make the engine a subscriber
-	ret.subscribers["engine"] = ec
-	go func() {
-	engineloop:
+	ret.subscribers = make(map[string]*subscriber)
+	ret.spectators = make(map[string]chan struct{})
+	ret.engineWake = make(chan struct{}, 1)
+	ret.engineDone = make(chan struct{})
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+	go ret.runEngine()
+	return ret
+}
+
+//engineEvent is an event waiting on the engine, along with the game as it was
+//right after the event was applied. Logged is the number of responses to it
+//already in the log, which the engine doesn't submit again.
+type engineEvent struct {
+	e      Event
+	g      Game
+	logged int
+}
+
+//queueEngine hands the event to the engine. The caller must hold the lock.
+func (sh *SecretHitler) queueEngine(e Event, g Game) {
+	sh.queueEngineEvent(engineEvent{e: e, g: g})
+}
+
+func (sh *SecretHitler) queueEngineEvent(ee engineEvent) {
+	sh.engineQueue = append(sh.engineQueue, ee)
+	select {
+	case sh.engineWake <- struct{}{}:
+	default:
+	}
+}
+
+//runEngine gives the engine every event in the order they were applied. Each
+//one is processed against the game as it was right after that event, not the
+//current game, otherwise two events in quick succession (such as the last two
+//votes of a round) would both see the final state and the engine would respond
+//to it twice.
+func (sh *SecretHitler) runEngine() {
+	defer close(sh.engineDone)
+	for {
+		select {
+		case <-sh.ctx.Done():
+			return
+		case <-sh.engineWake:
+		}
 		for {
-			select {
-			case e := <-ec:
-				if e == nil {
-					fmt.Println("Exiting game engine loop via nil read")
-					break engineloop
-				}
-				if nes, err := ret.Engine(e); err == nil {
-					for _, ne := range nes {
-						ctx := context.Background()
-						ctx = context.WithValue(ctx, "playerID", PlayerIDEngine)
-						err = ret.SubmitEvent(ctx, ne)
-						if err != nil {
-							fmt.Println("engine:Submit Error:", err)
-						}
-					}
-				}
-				//If the game is over, shut down the game engine and clean it up as a subscriber
-				if ret.Game.State == GameStateFinished {
-					ret.m.Lock()
-					if ret.subscribers["engine"] != nil {
-						close(ec)
-					}
-					delete(ret.subscribers, "engine")
-					ret.m.Unlock()
-					break engineloop
+			sh.m.Lock()
+			if len(sh.engineQueue) == 0 {
+				sh.m.Unlock()
+				break
+			}
+			next := sh.engineQueue[0]
+			sh.engineQueue = sh.engineQueue[1:]
+			sh.m.Unlock()
+			if re, ok := next.e.(RequestEvent); ok && !re.Deadline.IsZero() {
+				sh.armTimeout(re)
+			}
+			nes, err := next.g.Engine(next.e)
+			if err != nil {
+				sh.reportError(next.g, next.e, nil, err)
+				nes = nil
+			}
+			if next.logged > len(nes) {
+				next.logged = len(nes)
+			}
+			nes = nes[next.logged:]
+			for _, ne := range nes {
+				ctx := WithViewer(sh.ctx, EngineViewer())
+				err = sh.SubmitEvent(ctx, ne)
+				if err != nil && !errors.Is(err, ErrClosed) {
+					sh.reportError(next.g, next.e, ne, err)
 				}
 			}
+			//If the game is over, shut down the game engine
+			sh.m.RLock()
+			finished := sh.Game.State == GameStateFinished
+			sh.m.RUnlock()
+			if finished {
+				sh.logger().Info("game finished", "gameId", next.g.ID)
+				return
+			}
 		}
-		fmt.Println("Exiting game engine loop via loop break")
-	}()
-	return ret
+	}
 }
 
 type SecretHitler struct {
 	Game
 
 	Log io.Writer
-	m   sync.RWMutex
+	//Chain, when set, chains every event written to the Log to the one before
+	//it, so the log can be checked with VerifyLog. Events appended to the
+	//Store are not chained.
+	Chain *Chain
+	Store EventStore
+	//SnapshotInterval is the number of events between snapshots of the game,
+	//taken when the Store is also a SnapshotStore
+	SnapshotInterval int
+	//CheckInvariants sets whether the game is checked for invariants after
+	//every event, and what to do with an event that breaks them
+	CheckInvariants InvariantMode
+	//Fanout sets how events are queued for each subscriber
+	Fanout SubscriberOptions
+	//Logger is where the game logs to, with structured fields for the game and
+	//the event, slog.Default() when nil
+	Logger *slog.Logger
+	m      sync.RWMutex
+
+	//subM guards the subscribers apart from the game, so removing one never
+	//waits on a broadcast
+	subM        sync.Mutex
+	subscribers map[string]*subscriber
+	spectators  map[string]chan struct{}
+	broadcastID int
+	//subsClosed is set once the game is closing, after which no subscriber is
+	//added. workers are the goroutines sending to subscribers.
+	subsClosed  bool
+	workers     sync.WaitGroup
+	engineQueue []engineEvent
+	engineWake  chan struct{}
+	engineDone  chan struct{}
+	timers      map[int]*time.Timer
+	//firing are the timeouts that have fired and are still running
+	firing sync.WaitGroup
+	//ctx is cancelled when the game is closed, which stops the engine
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+//LoadSecretHitler rebuilds a running game from an event log written by
+//SubmitEvent. The events are replayed straight onto the game state without
+//being validated or run through the engine. If the reader is also a writer
+//(such as a file opened for reading and appending) new events will continue to
+//be appended to it. A chained log is refused if any of its links are broken,
+//and new events continue the chain; set the Chain Key to keep signing them.
+//The signatures of the links are not checked, and a log that isn't chained is
+//loaded as it is; use LoadSignedSecretHitler for a log that must be signed.
+func LoadSecretHitler(r io.Reader) (*SecretHitler, error) {
+	return loadSecretHitler(r, nil)
+}
+
+//LoadSignedSecretHitler rebuilds a running game from a chained event log signed
+//with the key, like LoadSecretHitler. The log is refused with a *LogError if it
+//isn't chained, or if any link is broken or wasn't signed with the key. New
+//events continue the chain and are signed with the key.
+func LoadSignedSecretHitler(r io.Reader, key []byte) (*SecretHitler, error) {
+	if len(key) == 0 {
+		return nil, errors.New("A key is required to check the signatures")
+	}
+	return loadSecretHitler(r, key)
+}
+
+func loadSecretHitler(r io.Reader, key []byte) (*SecretHitler, error) {
+	lr := newLogReader(r, key)
+	events, err := lr.readAll()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil && !lr.chained && len(events) > 0 {
+		return nil, &LogError{Line: 1, EventID: events[0].GetID(), Problem: "Log is not chained"}
+	}
+	ret, err := replaySecretHitler(Game{}, events)
+	if err != nil {
+		return nil, err
+	}
+	if w, ok := r.(io.Writer); ok {
+		ret.Log = w
+	}
+	if lr.chained || key != nil {
+		ret.Chain = &Chain{Key: key, Prev: lr.chain.Prev}
+	}
+	ret.resume(Game{}, events, nil)
+	return ret, nil
+}
+
+//LoadSecretHitlerFromStore rebuilds a running game from the events kept in the
+//store. If the store also keeps snapshots, only the events after the latest
+//snapshot are replayed. New events will continue to be appended to the store.
+//Stored events are not chained, so a store that must be tamper evident should
+//seal them, as the stores of the store package do with a Cipher.
+func LoadSecretHitlerFromStore(s EventStore, gameID string) (*SecretHitler, error) {
+	start := Game{}
+	if ss, ok := s.(SnapshotStore); ok {
+		var err error
+		start, err = ss.LoadSnapshot(gameID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	//Include the snapshotted event so the engine can resume from it
+	from := start.EventID - 1
+	if from < 0 {
+		from = 0
+	}
+	events, err := s.LoadFrom(gameID, from)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, errors.New("Game not found")
+	}
+	replay := events
+	if start.EventID > 0 {
+		if events[0].GetID() != start.EventID {
+			return nil, errors.New("Snapshot does not match the event log")
+		}
+		replay = events[1:]
+	}
+	ret, err := replaySecretHitler(start, replay)
+	if err != nil {
+		return nil, err
+	}
+	ret.Store = s
+	//Requests from before the snapshot may still be waiting on players
+	pending, err := pendingRequests(s, ret.Game, events[0].GetID())
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+	ret.resume(start, events, pending)
+	return ret, nil
+}
+
+//pendingRequests scans back through the events of the game before the given
+//one, and returns the requests with deadlines that are still waiting on
+//players, oldest first. It stops at the first request that has been answered,
+//as every request before that one has been answered too.
+func pendingRequests(s EventStore, g Game, before int) ([]Event, error) {
+	if g.Timeouts == (Timeouts{}) || g.State == GameStateFinished {
+		return nil, nil
+	}
+	ret := []Event{}
+	for n := 16; before > 1; n *= 2 {
+		from := before - 1 - n
+		if from < 0 {
+			from = 0
+		}
+		events, err := s.LoadFrom(g.ID, from)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(events) - 1; i >= 0; i-- {
+			re, ok := events[i].(RequestEvent)
+			if !ok || re.ID >= before {
+				continue
+			}
+			if len(g.Timeout(re)) == 0 {
+				return ret, nil
+			}
+			if !re.Deadline.IsZero() {
+				ret = append([]Event{re}, ret...)
+			}
+		}
+		before = from + 1
+	}
+	return ret, nil
+}
+
+//readEvents reads every event from a log written by SubmitEvent, refusing a
+//chained log with a broken link
+func readEvents(r io.Reader) ([]Event, error) {
+	return newLogReader(r, nil).readAll()
+}
 
-	subscribers map[string]chan<- Event
+func replaySecretHitler(start Game, events []Event) (*SecretHitler, error) {
+	ret := NewSecretHitler()
+	ret.Game = start
+	var err error
+	for _, e := range events {
+		ret.Game, err = ret.Game.Replay(e)
+		if err != nil {
+			ret.stopEngine()
+			return nil, err
+		}
+	}
+	if ret.Game.State == GameStateFinished {
+		ret.stopEngine()
+	}
+	return ret, nil
+}
+
+//resume picks the game up where its log left off. Any requests still waiting on
+//players have their deadlines scheduled again, and the engine is handed back
+//the last player event it responds to, as it may have gone down before it
+//submitted all of its responses. The events were replayed onto start, and
+//pending are requests from before them.
+func (sh *SecretHitler) resume(start Game, events []Event, pending []Event) {
+	if len(events) == 0 || sh.Game.State == GameStateFinished {
+		return
+	}
+	for _, e := range append(pending, events...) {
+		if re, ok := e.(RequestEvent); ok && !re.Deadline.IsZero() && sh.Game.Timeout(re) != nil {
+			sh.armTimeout(re)
+		}
+	}
+	if ee, ok := sh.lastResponse(start, events); ok {
+		sh.m.Lock()
+		sh.queueEngineEvent(ee)
+		sh.m.Unlock()
+	}
+}
+
+//lastResponse finds the last player event the engine responds to, along with
+//the game as it was right after it. The engine submits its responses one at a
+//time, so it may have gone down with only some of them in the log, such as a
+//vote result without the request that follows it. Those come first, in order,
+//among the events after the player event, and are counted as logged.
+func (sh *SecretHitler) lastResponse(start Game, events []Event) (engineEvent, bool) {
+	//The game right after each player event
+	played := []engineEvent{}
+	at := []int{}
+	g := start
+	for i, e := range events {
+		//The snapshotted event is already part of the start
+		if e.GetID() > g.EventID {
+			var err error
+			if g, err = g.Replay(e); err != nil {
+				return engineEvent{}, false
+			}
+		}
+		if strings.HasPrefix(e.GetType(), "player.") {
+			played = append(played, engineEvent{e: e, g: g})
+			at = append(at, i)
+		}
+	}
+	for j := len(played) - 1; j >= 0; j-- {
+		ee := played[j]
+		nes, err := ee.g.Engine(ee.e)
+		if err != nil {
+			sh.reportError(ee.g, ee.e, nil, err)
+			return engineEvent{}, false
+		}
+		if len(nes) == 0 {
+			continue
+		}
+		for _, e := range events[at[j]+1:] {
+			if ee.logged < len(nes) && e.GetType() == nes[ee.logged].GetType() {
+				ee.logged++
+			}
+		}
+		return ee, ee.logged < len(nes)
+	}
+	return engineEvent{}, false
 }
 
 func (sh *SecretHitler) SubmitEvent(ctx context.Context, e Event) error {
 	sh.m.Lock()
 	defer sh.m.Unlock()
+	if sh.closed.Load() {
+		return ErrClosed
+	}
 	//Do the validate here
 	err := sh.Validate(ctx, e)
 	if err != nil {
@@ -113,95 +430,142 @@ func (sh *SecretHitler) SubmitEvent(ctx context.Context, e Event) error {
 	if err != nil {
 		return err
 	}
-	sh.Game = g
-	//Persist the event to a file
-	if sh.Log != nil {
-		enc := json.NewEncoder(sh.Log)
-		err := enc.Encode(ne)
+	if err = sh.checkInvariants(g, ne); err != nil {
+		return err
+	}
+	//Persist the event before committing the new state, so a failed write
+	//leaves the game as it was
+	if sh.Store != nil {
+		err = sh.Store.Append(g.ID, ne)
 		if err != nil {
 			return err
 		}
 	}
-	go func() {
-		sh.BroadcastEvent(ne)
-	}()
+	if sh.Log != nil {
+		if err = sh.writeLog(ne); err != nil {
+			return err
+		}
+	}
+	sh.Game = g
+	//The event is already persisted, a failed snapshot only costs replay time
+	if err := sh.snapshot(); err != nil {
+		sh.logger().Warn("snapshot failed", append(eventAttrs(g.ID, ne), "err", err)...)
+	}
+	sh.queueEngine(ne, g)
+	sh.broadcast(ne)
 	return nil
 }
 
+//FilteredGame returns the current game state as seen by the player in the context
+func (sh *SecretHitler) FilteredGame(ctx context.Context) Game {
+	sh.m.RLock()
+	defer sh.m.RUnlock()
+	return sh.Game.Filter(ctx)
+}
+
+//AddSubscriber sends every event broadcast from now on to the channel, in
+//order. The events are queued as set by the Fanout, and a subscriber that falls
+//too far behind is dealt with by its backpressure policy.
 func (sh *SecretHitler) AddSubscriber(key string, channel chan<- Event) {
+	sh.AddSubscriberWithOptions(key, channel, sh.Fanout)
+}
+
+//AddSubscriberWithOptions is AddSubscriber with queueing of its own
+func (sh *SecretHitler) AddSubscriberWithOptions(key string, channel chan<- Event, opts SubscriberOptions) {
+	sh.m.Lock()
+	defer sh.m.Unlock()
 	if sh.Game.State == GameStateFinished {
 		return
 	}
-	sh.m.Lock()
-	sh.subscribers[key] = channel
-	sh.m.Unlock()
+	sh.addSubscriber(key, channel, opts)
 }
 
+//RemoveSubscriber stops sending events to the subscriber. It doesn't wait on
+//the game, and once it returns nothing more is sent on the channel.
 func (sh *SecretHitler) RemoveSubscriber(key string) {
-	sh.m.Lock()
+	sh.subM.Lock()
+	s, ok := sh.subscribers[key]
 	delete(sh.subscribers, key)
-	sh.m.Unlock()
+	stop, spectating := sh.spectators[key]
+	delete(sh.spectators, key)
+	sh.subM.Unlock()
+	if ok {
+		s.close(false)
+	}
+	if spectating {
+		close(stop)
+	}
 }
 
+//BroadcastEvent sends the event to every subscriber, in order with the events
+//submitted to the game
 func (sh *SecretHitler) BroadcastEvent(e Event) {
-	sh.m.RLock()
-	defer sh.m.RUnlock()
-	for k, _ := range sh.subscribers {
-		sh.subscribers[k] <- e
-	}
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	sh.broadcast(e)
 }
 
-//ReadEventLog will read the associated event log and publish all the events to the included channel
+//ReadEventLog will read the associated event log and publish all the events to the included channel.
+//A chained log is checked as it is read, and reading stops with a *LogError at the first broken link.
 func ReadEventLog(r io.Reader, c chan<- Event) error {
-	//Read in a byte slice
-	d := json.NewDecoder(r)
-	var err error
-	for err == nil {
-		var rm json.RawMessage
-		err = d.Decode(&rm)
+	defer close(c)
+	lr := newLogReader(r, nil)
+	for {
+		e, err := lr.next()
 		if err != nil {
-			close(c)
-			return err
-		}
-		e, err := UnmarshalEvent(rm)
-		if err != nil {
-			close(c)
 			return err
 		}
 		c <- e
 	}
-	close(c)
-	return nil
 }
 
+//Token is the claim the engine signs whenever it shows a player something
+//secret, which the player can later present in an assertion. Along with what
+//was shown it carries the standard jwt claims: the game it was issued by as the
+//audience, when it was issued and when it expires. The policies or party that
+//were shown are carried in the token, so a claim made with it can be checked.
 type Token struct {
-	EventID       int    `json:"eventId"`
-	PlayerID      string `json:"playerId"`
-	Assertion     string `json:"assertion"`
-	RoundID       int    `json:"roundId"`
-	OtherPlayerID string `json:"otherPlayerId,omitempty"`
-	PolicyCount   int    `json:"policyCount,omitempty"`
+	Audience      string   `json:"aud,omitempty"`
+	IssuedAt      int64    `json:"iat,omitempty"`
+	ExpiresAt     int64    `json:"exp,omitempty"`
+	EventID       int      `json:"eventId"`
+	PlayerID      string   `json:"playerId"`
+	Assertion     string   `json:"assertion"`
+	RoundID       int      `json:"roundId"`
+	OtherPlayerID string   `json:"otherPlayerId,omitempty"`
+	PolicyCount   int      `json:"policyCount,omitempty"`
+	Policies      []string `json:"policies,omitempty"`
+	Party         string   `json:"party,omitempty"`
 }
 
+//Game is the state of a game. The Seed drives the shuffles, roles and first
+//president, but not the token Keys, which are read from crypto/rand so that
+//they can't be worked out from the seed. A game replayed from its seed deals
+//the same game but signs its tokens with new keys.
 type Game struct {
-	ID                         string   `json:"id,omitempty"`
-	Secret                     string   `json:"secret,omitempty"`
-	EventID                    int      `json:"eventId,omitempty"`
-	State                      string   `json:"state,omitempty"`
-	Draw                       []string `json:"draw,omitempty"`
-	Discard                    []string `json:"discard,omitempty"`
-	Liberal                    int      `json:"liberal,omitempty"`
-	Fascist                    int      `json:"fascist,omitempty"`
-	ElectionTracker            int      `json:"electionTracker,omitempty"`
-	Players                    []Player `json:"players,omitempty"`
-	Round                      Round    `json:"round,omitempty"`
-	NextPresidentID            string   `json:"nextPresidentId,omitempty"`
-	PreviousPresidentID        string   `json:"previousPresidentId,omitempty"`
-	PreviousChancellorID       string   `json:"previousChancellorId,omitempty"`
-	PreviousEnactedPolicy      string   `json:"previousEnactedPolicy,omitempty"`
-	SpecialElectionRoundID     int      `json:"specialElectionRoundId,omitempty"`
-	SpecialElectionPresidentID string   `json:"specialElectionPresidentId,omitempty"`
-	WinningParty               string   `json:"winningParty,omitempty"`
+	ID                         string        `json:"id,omitempty"`
+	Secret                     string        `json:"secret,omitempty"`
+	Keys                       []TokenKey    `json:"keys,omitempty"`
+	TokenTTL                   time.Duration `json:"tokenTtl,omitempty"`
+	HonestClaims               bool          `json:"honestClaims,omitempty"`
+	Seed                       int64         `json:"seed,omitempty"`
+	EventID                    int           `json:"eventId,omitempty"`
+	State                      string        `json:"state,omitempty"`
+	Draw                       []string      `json:"draw,omitempty"`
+	Discard                    []string      `json:"discard,omitempty"`
+	Liberal                    int           `json:"liberal,omitempty"`
+	Fascist                    int           `json:"fascist,omitempty"`
+	ElectionTracker            int           `json:"electionTracker,omitempty"`
+	Players                    []Player      `json:"players,omitempty"`
+	Round                      Round         `json:"round,omitempty"`
+	NextPresidentID            string        `json:"nextPresidentId,omitempty"`
+	PreviousPresidentID        string        `json:"previousPresidentId,omitempty"`
+	PreviousChancellorID       string        `json:"previousChancellorId,omitempty"`
+	PreviousEnactedPolicy      string        `json:"previousEnactedPolicy,omitempty"`
+	SpecialElectionRoundID     int           `json:"specialElectionRoundId,omitempty"`
+	SpecialElectionPresidentID string        `json:"specialElectionPresidentId,omitempty"`
+	WinningParty               string        `json:"winningParty,omitempty"`
+	Timeouts                   Timeouts      `json:"timeouts,omitempty"`
 }
 
 func (g Game) GetPlayerByID(id string) (Player, error) {
@@ -0,0 +1,132 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoadSecretHitler(t *testing.T) {
+	//Build a log of five players joining and readying up
+	g := Game{}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for i := 1; i <= 5; i++ {
+		for _, typ := range []string{TypePlayerJoin, TypePlayerReady} {
+			var ne Event
+			var err error
+			g, ne, err = g.Apply(PlayerEvent{
+				BaseEvent: BaseEvent{Type: typ},
+				Player:    Player{ID: strconv.Itoa(i)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc.Encode(ne)
+		}
+		if i == 1 {
+			var ne Event
+			g, ne, _ = g.Apply(MessageEvent{
+				BaseEvent: BaseEvent{Type: TypePlayerMessage},
+				PlayerID:  "1",
+				Message:   "hello",
+			})
+			enc.Encode(ne)
+		}
+	}
+
+	sh, err := LoadSecretHitler(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.Close()
+	ctx := WithViewer(context.Background(), AdminViewer())
+	lg := sh.FilteredGame(ctx)
+	if lg.EventID != 11 || len(lg.Players) != 5 {
+		t.Fatal("Expected the event id and players to be restored", lg.EventID, lg.Players)
+	}
+	if !lg.Players[0].LastAction.Equal(g.Players[0].LastAction) {
+		t.Fatal("Expected event moments to be preserved")
+	}
+	//The log ended on the last player readying up, so the engine should start the game
+	deadline := time.Now().Add(time.Second)
+	for sh.FilteredGame(ctx).State != GameStateInit {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the engine to start the game after loading")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplayOutOfOrder(t *testing.T) {
+	g := Game{}
+	_, err := g.Replay(PlayerEvent{
+		BaseEvent: BaseEvent{ID: 2, Type: TypePlayerJoin},
+		Player:    Player{ID: "1"},
+	})
+	if err == nil {
+		t.Fatal("Expected an error replaying an event out of order")
+	}
+}
+
+func TestLoadSubmitsMissingResponses(t *testing.T) {
+	//The engine went down after logging the vote result, but before the
+	//policies were dealt to the president
+	g := Game{}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	start := votingGame()
+	start.Round.Votes = append(start.Round.Votes, Vote{PlayerID: "3", Vote: true}, Vote{PlayerID: "4", Vote: true})
+	for i := 0; i < 17; i++ {
+		start.Draw = append(start.Draw, PolicyFascist)
+	}
+	var ne Event
+	var err error
+	for _, e := range []Event{
+		GameEvent{BaseEvent: BaseEvent{Type: TypeGameUpdate}, Game: start},
+		PlayerVoteEvent{BaseEvent: BaseEvent{Type: TypePlayerVote}, PlayerID: "5", Vote: true},
+	} {
+		if g, ne, err = g.Apply(e); err != nil {
+			t.Fatal(err)
+		}
+		enc.Encode(ne)
+	}
+	responses, err := g.Engine(ne)
+	if err != nil || len(responses) < 2 {
+		t.Fatal("Expected the vote result and more", responses, err)
+	}
+	if _, ne, err = g.Apply(responses[0]); err != nil {
+		t.Fatal(err)
+	}
+	enc.Encode(ne)
+
+	sh, err := LoadSecretHitler(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithViewer(context.Background(), AdminViewer())
+	deadline := time.Now().Add(time.Second)
+	for sh.FilteredGame(ctx).Round.State != RoundStateLegislating {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the engine to carry on after the vote result")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sh.Close()
+	//Only the responses that were missing were added to the log
+	events, err := readEvents(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(responses)-1 {
+		t.Fatal("Expected only the missing responses", events)
+	}
+	for i, e := range events {
+		if e.GetType() != responses[i+1].GetType() {
+			t.Fatal("Expected the missing responses in order", i, e.GetType())
+		}
+	}
+}
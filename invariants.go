@@ -0,0 +1,199 @@
+package sh
+
+import (
+	"fmt"
+	"strings"
+)
+
+//InvariantMode sets what SubmitEvent does with an event that leaves the game
+//breaking one of its invariants
+type InvariantMode int
+
+const (
+	//InvariantsOff skips the checks
+	InvariantsOff InvariantMode = iota
+	//InvariantsFlag logs the broken invariants but keeps the event
+	InvariantsFlag
+	//InvariantsReject logs the broken invariants and rejects the event
+	InvariantsReject
+)
+
+//InvariantError is returned by SubmitEvent when an event is rejected for
+//breaking the game invariants
+type InvariantError struct {
+	Event  Event
+	Errors []error
+}
+
+func (e InvariantError) Error() string {
+	s := []string{}
+	for _, err := range e.Errors {
+		s = append(s, err.Error())
+	}
+	return fmt.Sprintf("Event %d (%s) breaks the game invariants: %s", e.Event.GetID(), e.Event.GetType(), strings.Join(s, "; "))
+}
+
+//fascistsFor is the number of players on the fascist team, hitler included, for
+//the number of players in the game
+func fascistsFor(players int) int {
+	switch {
+	case players > 8:
+		return 4
+	case players > 6:
+		return 3
+	}
+	return 2
+}
+
+//Invariants checks that the game state is sane, returning an error for every
+//rule that it breaks. Every policy must be accounted for, the trackers must be
+//in range, the roles must have been dealt correctly for the number of players,
+//and the government must be made up of living players.
+func Invariants(g Game) []error {
+	ret := []error{}
+	fail := func(format string, a ...interface{}) {
+		ret = append(ret, fmt.Errorf(format, a...))
+	}
+
+	if len(g.Players) > 10 {
+		fail("%d players, no more than 10 can play", len(g.Players))
+	}
+	seen := map[string]bool{}
+	for _, p := range g.Players {
+		if seen[p.ID] {
+			fail("Player %s has joined more than once", p.ID)
+		}
+		seen[p.ID] = true
+	}
+	if g.State == GameStateLobby {
+		return ret
+	}
+
+	//The policies
+	liberal, fascist := g.Liberal, g.Fascist
+	for _, pile := range [][]string{g.Draw, g.Discard, g.Round.Policies} {
+		for _, p := range pile {
+			switch p {
+			case PolicyLiberal:
+				liberal++
+			case PolicyFascist:
+				fascist++
+			default:
+				fail("Unknown policy %q", p)
+			}
+		}
+	}
+	if liberal+fascist != 17 {
+		fail("%d policies in the game, expected 17", liberal+fascist)
+	}
+	if liberal != 6 || fascist != 11 {
+		fail("%d liberal and %d fascist policies, expected 6 and 11", liberal, fascist)
+	}
+	if g.Liberal < 0 || g.Liberal > 5 {
+		fail("Liberal track at %d, expected 0-5", g.Liberal)
+	}
+	if g.Fascist < 0 || g.Fascist > 6 {
+		fail("Fascist track at %d, expected 0-6", g.Fascist)
+	}
+	if g.ElectionTracker < 0 || g.ElectionTracker > 3 {
+		fail("Election tracker at %d, expected 0-3", g.ElectionTracker)
+	}
+	if len(g.Round.Policies) > 3 {
+		fail("%d policies in the round, no more than 3 are drawn", len(g.Round.Policies))
+	}
+
+	//The roles
+	if len(g.Players) < 5 {
+		fail("%d players, at least 5 are needed", len(g.Players))
+	}
+	fascists, hitlers, executed := 0, 0, 0
+	for _, p := range g.Players {
+		switch p.Role {
+		case RoleLiberal:
+			if p.Party != PartyLiberal {
+				fail("Player %s is a liberal in the %s party", p.ID, p.Party)
+			}
+		case RoleFascist, RoleHitler:
+			if p.Party != PartyFascist {
+				fail("Player %s is a %s in the %s party", p.ID, p.Role, p.Party)
+			}
+			fascists++
+			if p.Role == RoleHitler {
+				hitlers++
+			}
+		default:
+			fail("Player %s has unknown role %q", p.ID, p.Role)
+		}
+		if p.ExecutedBy != "" {
+			executed++
+		}
+	}
+	if fascists != fascistsFor(len(g.Players)) {
+		fail("%d fascists in a %d player game, expected %d", fascists, len(g.Players), fascistsFor(len(g.Players)))
+	}
+	if hitlers != 1 {
+		fail("%d players are hitler, expected 1", hitlers)
+	}
+	if executed > 2 {
+		fail("%d players executed, no more than 2 can be", executed)
+	}
+
+	if g.State == GameStateFinished {
+		if g.WinningParty != PartyLiberal && g.WinningParty != PartyFascist {
+			fail("Game finished without a winning party")
+		}
+		return ret
+	}
+	if g.State != GameStateStarted {
+		return ret
+	}
+
+	//The government
+	alive := func(role, id string) {
+		p, err := g.GetPlayerByID(id)
+		if err != nil {
+			fail("%s %q is not a player", role, id)
+		} else if p.ExecutedBy != "" {
+			fail("%s %s has been executed", role, id)
+		}
+	}
+	alive("President", g.Round.PresidentID)
+	//The chancellor or next president may be executed during the executive
+	//action, the next round will skip over them
+	if g.Round.State != RoundStateExecutiveAction {
+		if g.Round.ChancellorID != "" {
+			alive("Chancellor", g.Round.ChancellorID)
+		}
+		alive("Next president", g.NextPresidentID)
+	}
+	voted := map[string]bool{}
+	for _, v := range g.Round.Votes {
+		if voted[v.PlayerID] {
+			fail("Player %s voted more than once", v.PlayerID)
+		}
+		voted[v.PlayerID] = true
+		//The votes stay with the round, and a voter may be executed after
+		if g.Round.State == RoundStateVoting {
+			alive("Voter", v.PlayerID)
+		}
+	}
+	return ret
+}
+
+//checkInvariants applies the invariant mode to the state an event produced. It
+//returns an error if the event should be rejected.
+func (sh *SecretHitler) checkInvariants(g Game, e Event) error {
+	if sh.CheckInvariants == InvariantsOff {
+		return nil
+	}
+	errs := Invariants(g)
+	if len(errs) == 0 {
+		return nil
+	}
+	ie := InvariantError{Event: e, Errors: errs}
+	sh.logger().Warn("invariants broken", append(eventAttrs(g.ID, e), "err", ie)...)
+	if sh.CheckInvariants == InvariantsReject {
+		return ie
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package sh
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func startedGame() Game {
+	g := Game{
+		ID:              "invariants",
+		State:           GameStateStarted,
+		NextPresidentID: "2",
+		Round: Round{
+			ID:          1,
+			PresidentID: "1",
+			State:       RoundStateNominating,
+		},
+	}
+	roles := []string{RoleLiberal, RoleLiberal, RoleLiberal, RoleFascist, RoleHitler}
+	for i, r := range roles {
+		p := Player{ID: strconv.Itoa(i + 1), Role: r, Party: PartyLiberal}
+		if r != RoleLiberal {
+			p.Party = PartyFascist
+		}
+		g.Players = append(g.Players, p)
+	}
+	for i := 0; i < 17; i++ {
+		if i < 6 {
+			g.Draw = append(g.Draw, PolicyLiberal)
+		} else {
+			g.Draw = append(g.Draw, PolicyFascist)
+		}
+	}
+	return g
+}
+
+func TestInvariants(t *testing.T) {
+	if errs := Invariants(startedGame()); len(errs) != 0 {
+		t.Fatal("Expected a sane game to pass", errs)
+	}
+	if errs := Invariants(Game{}); len(errs) != 0 {
+		t.Fatal("Expected an empty lobby to pass", errs)
+	}
+
+	broken := map[string]func(g *Game){
+		"policies in the game": func(g *Game) { g.Draw = g.Draw[1:] },
+		"Election tracker":     func(g *Game) { g.ElectionTracker = 4 },
+		"fascists":             func(g *Game) { g.Players[0].Role, g.Players[0].Party = RoleFascist, PartyFascist },
+		"Next president":       func(g *Game) { g.Players[1].ExecutedBy = "1" },
+		"President":            func(g *Game) { g.Round.PresidentID = "6" },
+		"voted more than once": func(g *Game) { g.Round.Votes = []Vote{{PlayerID: "1"}, {PlayerID: "1"}} },
+	}
+	for want, breakIt := range broken {
+		g := startedGame()
+		breakIt(&g)
+		errs := Invariants(g)
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("Expected invariant to be broken", want, errs)
+		}
+	}
+}
+
+func TestSubmitEventInvariants(t *testing.T) {
+	ctx := WithViewer(context.Background(), AdminViewer())
+	//Losing a policy from the draw pile breaks the policy count
+	e := GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{Draw: startedGame().Draw[1:]},
+	}
+
+	sh := NewSecretHitler()
+	defer sh.Close()
+	sh.Game = startedGame()
+	sh.CheckInvariants = InvariantsReject
+	err := sh.SubmitEvent(ctx, e)
+	if _, ok := err.(InvariantError); !ok {
+		t.Fatal("Expected the event to be rejected", err)
+	}
+	if sh.Game.EventID != 0 || len(sh.Game.Draw) != 17 {
+		t.Fatal("Expected the game to be left as it was")
+	}
+
+	sh.CheckInvariants = InvariantsFlag
+	if err = sh.SubmitEvent(ctx, e); err != nil {
+		t.Fatal("Expected a flagged event to be kept", err)
+	}
+	if len(sh.Game.Draw) != 16 {
+		t.Fatal("Expected the event to be applied")
+	}
+}
@@ -0,0 +1,112 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+//ErrClosed is returned for events submitted to a game that has been closed
+var ErrClosed = errors.New("Game is closed")
+
+//DrainTimeout is how long Close waits for the subscribers to receive the events
+//already queued for them
+var DrainTimeout = time.Second
+
+//Run ties the game to the context. It returns nil once the game is finished
+//and the engine has stopped, or closes the game when the context is done first
+//and returns why.
+func (sh *SecretHitler) Run(ctx context.Context) error {
+	select {
+	case <-sh.engineDone:
+		if sh.closed.Load() {
+			return ErrClosed
+		}
+		return nil
+	case <-ctx.Done():
+		if err := sh.Close(); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+}
+
+//Close shuts the game down. New events are refused, the subscribers are given
+//up to the DrainTimeout to receive what is queued for them and are then
+//removed, the engine and the request timeouts are stopped, and the log is
+//flushed and closed if it can be. It waits for every goroutine of the game to
+//exit, and returns the same error however many times it is called.
+func (sh *SecretHitler) Close() error {
+	sh.closeOnce.Do(func() {
+		sh.closed.Store(true)
+		sh.cancel()
+		sh.drain(time.Now().Add(DrainTimeout))
+		sh.stopEngine()
+		sh.closeErr = sh.closeLog()
+		sh.m.RLock()
+		id := sh.Game.ID
+		sh.m.RUnlock()
+		if sh.closeErr != nil {
+			sh.logger().Error("game closed", "gameId", id, "err", sh.closeErr)
+		} else {
+			sh.logger().Info("game closed", "gameId", id)
+		}
+	})
+	return sh.closeErr
+}
+
+//drain stops new subscribers, waits for the queued events to be sent until the
+//deadline, and then removes every subscriber and waits for their goroutines to
+//exit. Removing them also frees any broadcast blocked on one, so nothing can be
+//left holding the game lock.
+func (sh *SecretHitler) drain(deadline time.Time) {
+	sh.subM.Lock()
+	sh.subsClosed = true
+	sh.subM.Unlock()
+	for time.Now().Before(deadline) {
+		//The event being sent is off the queue, but still lags
+		pending := 0
+		for _, s := range sh.SubscriberStats() {
+			if !s.Disconnected {
+				pending += s.Queued + s.Lag
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sh.subM.Lock()
+	keys := []string{}
+	for k := range sh.subscribers {
+		keys = append(keys, k)
+	}
+	sh.subM.Unlock()
+	for _, k := range keys {
+		sh.RemoveSubscriber(k)
+	}
+	sh.workers.Wait()
+}
+
+//stopEngine stops the engine, waits for it to exit, and stops the request
+//timeouts
+func (sh *SecretHitler) stopEngine() {
+	sh.cancel()
+	<-sh.engineDone
+	sh.stopTimeouts()
+}
+
+//closeLog flushes the log, if it buffers, and closes it, if it can be
+func (sh *SecretHitler) closeLog() error {
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	var errs []error
+	if f, ok := sh.Log.(interface{ Flush() error }); ok {
+		errs = append(errs, f.Flush())
+	}
+	if c, ok := sh.Log.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,260 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+//bufferedLog counts the calls Close makes on a buffered log
+type bufferedLog struct {
+	flushed, closed int
+}
+
+func (l *bufferedLog) Write(p []byte) (int, error) { return len(p), nil }
+func (l *bufferedLog) Flush() error                { l.flushed++; return nil }
+func (l *bufferedLog) Close() error                { l.closed++; return errors.New("closed") }
+
+func TestCloseRefusesEvents(t *testing.T) {
+	sh := NewSecretHitler()
+	l := new(bufferedLog)
+	sh.Log = l
+	if err := join(sh, "1"); err != nil {
+		t.Fatal(err)
+	}
+	err := sh.Close()
+	if err == nil || l.flushed != 1 || l.closed != 1 {
+		t.Fatal("Expected the log to be flushed and closed, and its error returned", err, l)
+	}
+	if again := sh.Close(); again != err || l.closed != 1 {
+		t.Fatal("Expected closing again to return the same error", again)
+	}
+	if err := join(sh, "2"); !errors.Is(err, ErrClosed) {
+		t.Fatal("Expected events to be refused once closed", err)
+	}
+	if err := sh.AddSpectator("streamer", Spectator{}, make(chan Event)); !errors.Is(err, ErrClosed) {
+		t.Fatal("Expected spectators to be refused once closed", err)
+	}
+	//Adding a subscriber is a no-op, and a subscription ends right away
+	sh.AddSubscriber("late", make(chan Event))
+	if stats := sh.SubscriberStats(); len(stats) != 0 {
+		t.Fatal("Expected no subscribers once closed", stats)
+	}
+	s, err := sh.Subscribe(context.Background(), PlayerViewer("1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-s.Events(); ok {
+		t.Fatal("Expected the subscription to be closed")
+	}
+	s.Close()
+}
+
+func TestCloseDrains(t *testing.T) {
+	sh := NewSecretHitler()
+	c := make(chan Event)
+	sh.AddSubscriber("slow", c)
+	broadcastN(sh, 10)
+	got := make(chan int)
+	go func() {
+		n := 0
+		for range c {
+			time.Sleep(time.Millisecond)
+			n++
+			if n == 10 {
+				break
+			}
+		}
+		got <- n
+	}()
+	sh.Close()
+	select {
+	case n := <-got:
+		if n != 10 {
+			t.Fatal("Expected the queued events to be sent before closing", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queued events to be sent before closing")
+	}
+}
+
+func TestCloseStuckSubscriber(t *testing.T) {
+	defer func(d time.Duration) { DrainTimeout = d }(DrainTimeout)
+	DrainTimeout = 50 * time.Millisecond
+	sh := NewSecretHitler()
+	c := make(chan Event)
+	sh.AddSubscriberWithOptions("stuck", c, SubscriberOptions{Backpressure: BackpressureBlock})
+	blocked := make(chan struct{})
+	go func() {
+		broadcastN(sh, DefaultQueueSize+2)
+		close(blocked)
+	}()
+	closed := make(chan struct{})
+	go func() {
+		sh.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a subscriber that never reads not to hold up closing")
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the blocked broadcast to be freed")
+	}
+	//The channel was the caller's, so it is left open but nothing more is sent
+	select {
+	case e := <-c:
+		t.Fatal("Expected nothing sent once closed", e)
+	default:
+	}
+}
+
+func TestRun(t *testing.T) {
+	sh := NewSecretHitler()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sh.Run(ctx) }()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected the context error", err)
+	}
+	if err := join(sh, "1"); !errors.Is(err, ErrClosed) {
+		t.Fatal("Expected the game to be closed with its context", err)
+	}
+	if err := sh.Run(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatal("Expected running a closed game to fail", err)
+	}
+
+	//A game that finishes stops its engine, and Run returns
+	sh = NewSecretHitler()
+	defer sh.Close()
+	sh.m.Lock()
+	sh.Game.State = GameStateFinished
+	sh.queueEngine(BaseEvent{Type: TypeGameUpdate}, sh.Game)
+	sh.m.Unlock()
+	go func() { done <- sh.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("Expected a finished game to run without error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Run to return once the game finished")
+	}
+}
+
+func TestCloseLeaksNoGoroutines(t *testing.T) {
+	defer func(d time.Duration) { DrainTimeout = d }(DrainTimeout)
+	DrainTimeout = 10 * time.Millisecond
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		sh := NewSecretHitler()
+		sh.Store = new(memStore)
+		ctx := WithViewer(context.Background(), AdminViewer())
+		sh.AddSubscriber("player", make(chan Event, 100))
+		sh.AddSubscriberWithOptions("stuck", make(chan Event), SubscriberOptions{QueueSize: 1, Backpressure: BackpressureDropOldest})
+		if err := sh.AddSpectator("streamer", Spectator{}, make(chan Event)); err != nil {
+			t.Fatal(err)
+		}
+		if err := join(sh, "1"); err != nil {
+			t.Fatal(err)
+		}
+		s, err := sh.Subscribe(ctx, PlayerViewer("1"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := join(sh, "2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := sh.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for range s.Events() {
+		}
+	}
+	//Goroutines from elsewhere in the runtime may take a moment to settle
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatal("Expected every goroutine of the games to exit", before, runtime.NumGoroutine(), string(buf[:runtime.Stack(buf, true)]))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//failingLog holds every write until it is released, and then fails it
+type failingLog struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (l *failingLog) Write(p []byte) (int, error) {
+	select {
+	case l.writing <- struct{}{}:
+	default:
+	}
+	<-l.release
+	return 0, errors.New("disk full")
+}
+
+//slowHandler takes a while to handle an error, and records what it handled
+type slowHandler struct {
+	slog.Handler
+	m    sync.Mutex
+	msgs []string
+}
+
+func (h *slowHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelError {
+		time.Sleep(50 * time.Millisecond)
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.msgs = append(h.msgs, r.Message)
+	return nil
+}
+
+func TestCloseWaitsForTimeouts(t *testing.T) {
+	sh := NewSecretHitler()
+	sh.Game = votingGame()
+	l := &failingLog{writing: make(chan struct{}, 1), release: make(chan struct{})}
+	sh.Log = l
+	h := &slowHandler{Handler: slog.NewTextHandler(io.Discard, nil)}
+	sh.Logger = slog.New(h)
+	sh.armTimeout(RequestEvent{
+		BaseEvent: BaseEvent{ID: 1, Type: TypeRequestVote},
+		PlayerID:  PlayerIDAll,
+		RoundID:   3,
+		Deadline:  time.Now(),
+	})
+	select {
+	case <-l.writing:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the timeout to fire")
+	}
+	//The timeout is in the middle of submitting its events as the game closes,
+	//and reports the event the log refused
+	closed := make(chan struct{})
+	go func() {
+		sh.Close()
+		close(closed)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(l.release)
+	<-closed
+	h.m.Lock()
+	defer h.m.Unlock()
+	if !slices.Contains(h.msgs, "engine event refused") {
+		t.Fatal("Expected Close to wait for the timeout to finish", h.msgs)
+	}
+}
@@ -0,0 +1,279 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Lobby tracks every running game instance. It creates games with generated
+//ids, lets players find open games and join them, and reaps finished games
+//once they have been kept around for the retention period. If a Store is set
+//each game persists its events to it, and LoadGames can bring those games back
+//after a restart.
+type Lobby struct {
+	Retention time.Duration
+	Store     EventStore
+	//SnapshotInterval is passed on to every game created by the lobby
+	SnapshotInterval int
+	//Timeouts are given to every game created by the lobby
+	Timeouts Timeouts
+	//TokenTTL is how long the tokens of every game created by the lobby are good
+	//for, zero for as long as the game lasts
+	TokenTTL time.Duration
+	//HonestClaims has every game created by the lobby check the claims made
+	//with a token against it
+	HonestClaims bool
+	//CheckInvariants is passed on to every game created or loaded by the lobby
+	CheckInvariants InvariantMode
+	//Fanout is passed on to every game created or loaded by the lobby
+	Fanout SubscriberOptions
+	//Logger is passed on to every game created or loaded by the lobby
+	Logger *slog.Logger
+	//VerifySnapshots replays every game from its first event when loading, and
+	//refuses to load a game whose snapshot doesn't match
+	VerifySnapshots bool
+
+	m        sync.RWMutex
+	games    map[string]*SecretHitler
+	finished map[string]time.Time
+}
+
+func NewLobby(retention time.Duration) *Lobby {
+	l := new(Lobby)
+	l.Retention = retention
+	l.games = make(map[string]*SecretHitler)
+	l.finished = make(map[string]time.Time)
+	return l
+}
+
+//CreateGame starts a new game and registers it with the lobby. The generated
+//id is submitted to the game as an admin update so it is part of the event log.
+func (l *Lobby) CreateGame() (*SecretHitler, error) {
+	id := genUUIDv4()
+	g := NewSecretHitler()
+	g.Store = l.Store
+	g.SnapshotInterval = l.SnapshotInterval
+	g.CheckInvariants = l.CheckInvariants
+	g.Fanout = l.Fanout
+	g.Logger = l.Logger
+	ctx := WithViewer(context.Background(), AdminViewer())
+	err := g.SubmitEvent(ctx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{ID: id, Timeouts: l.Timeouts, TokenTTL: l.TokenTTL, HonestClaims: l.HonestClaims},
+	})
+	if err != nil {
+		g.Close()
+		return nil, err
+	}
+	l.m.Lock()
+	l.games[id] = g
+	l.m.Unlock()
+	return g, nil
+}
+
+//AddGame registers an already running game, such as one rebuilt with
+//LoadSecretHitler, with the lobby.
+func (l *Lobby) AddGame(g *SecretHitler) error {
+	g.m.RLock()
+	id := g.Game.ID
+	g.m.RUnlock()
+	if id == "" {
+		return errors.New("Game has no id")
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	if _, ok := l.games[id]; ok {
+		return errors.New("Game already exists")
+	}
+	l.games[id] = g
+	return nil
+}
+
+//LoadGames rebuilds every unfinished game in the Store and adds it to the
+//lobby. It returns the ids of the loaded games.
+func (l *Lobby) LoadGames() ([]string, error) {
+	if l.Store == nil {
+		return nil, errors.New("No event store configured")
+	}
+	ids, err := l.Store.Games()
+	if err != nil {
+		return nil, err
+	}
+	loaded := []string{}
+	for _, id := range ids {
+		if l.VerifySnapshots {
+			if err = VerifySnapshot(l.Store, id); err != nil {
+				return loaded, fmt.Errorf("%s: %v", id, err)
+			}
+		}
+		g, err := LoadSecretHitlerFromStore(l.Store, id)
+		if err != nil {
+			return loaded, fmt.Errorf("%s: %v", id, err)
+		}
+		g.SnapshotInterval = l.SnapshotInterval
+		g.CheckInvariants = l.CheckInvariants
+		g.Fanout = l.Fanout
+		g.Logger = l.Logger
+		if g.Game.State == GameStateFinished {
+			g.Close()
+			continue
+		}
+		if err = l.AddGame(g); err != nil {
+			g.Close()
+			return loaded, fmt.Errorf("%s: %v", id, err)
+		}
+		loaded = append(loaded, id)
+	}
+	sort.Strings(loaded)
+	return loaded, nil
+}
+
+//GetGame returns the game with the given id
+func (l *Lobby) GetGame(id string) (*SecretHitler, error) {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	g, ok := l.games[id]
+	if !ok {
+		return nil, errors.New("Game not found")
+	}
+	return g, nil
+}
+
+//OpenGames lists the games that are still in the lobby state and have room for
+//another player, filtered for the player in the context.
+func (l *Lobby) OpenGames(ctx context.Context) []Game {
+	l.m.RLock()
+	defer l.m.RUnlock()
+	ret := []Game{}
+	for _, g := range l.games {
+		fg := g.FilteredGame(ctx)
+		if fg.State == GameStateLobby && len(fg.Players) < 10 {
+			ret = append(ret, fg)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+//PlayerGames lists the unfinished games the player in the context has joined,
+//filtered for them, so a player that reconnects can pick up where they left off
+func (l *Lobby) PlayerGames(ctx context.Context) []Game {
+	v := ViewerFrom(ctx)
+	l.m.RLock()
+	defer l.m.RUnlock()
+	ret := []Game{}
+	for _, g := range l.games {
+		fg := g.FilteredGame(ctx)
+		if _, err := fg.GetPlayerByID(v.PlayerID); err == nil && v.Kind == ViewerPlayer && fg.State != GameStateFinished {
+			ret = append(ret, fg)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+//Join adds the player in the context to the game with the given id
+func (l *Lobby) Join(ctx context.Context, id string) (*SecretHitler, error) {
+	g, err := l.GetGame(id)
+	if err != nil {
+		return nil, err
+	}
+	err = g.SubmitEvent(ctx, PlayerEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerJoin},
+		Player:    Player{ID: ViewerFrom(ctx).PlayerID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+//QuickJoin adds the player in the context to the fullest open game, creating a
+//new game if none are open.
+func (l *Lobby) QuickJoin(ctx context.Context) (*SecretHitler, error) {
+	open := l.OpenGames(ctx)
+	sort.SliceStable(open, func(i, j int) bool { return len(open[i].Players) > len(open[j].Players) })
+	for _, og := range open {
+		if g, err := l.Join(ctx, og.ID); err == nil {
+			return g, nil
+		}
+	}
+	g, err := l.CreateGame()
+	if err != nil {
+		return nil, err
+	}
+	return l.Join(ctx, g.FilteredGame(ctx).ID)
+}
+
+//Reap removes and shuts down any games that finished more than the retention
+//period before now, and has the Store forget them if it is a Forgetter. It
+//returns the ids of the reaped games.
+func (l *Lobby) Reap(now time.Time) []string {
+	l.m.Lock()
+	reaped := []string{}
+	closing := []*SecretHitler{}
+	for id, g := range l.games {
+		g.m.RLock()
+		state := g.Game.State
+		g.m.RUnlock()
+		if state != GameStateFinished {
+			continue
+		}
+		finishedAt, ok := l.finished[id]
+		if !ok {
+			l.finished[id] = now
+			finishedAt = now
+		}
+		if now.Sub(finishedAt) >= l.Retention {
+			delete(l.games, id)
+			delete(l.finished, id)
+			reaped = append(reaped, id)
+			closing = append(closing, g)
+		}
+	}
+	l.m.Unlock()
+	for _, g := range closing {
+		g.Close()
+		if f, ok := l.Store.(Forgetter); ok {
+			if err := f.Forget(g.ID); err != nil {
+				g.logger().Error("store failed to forget game", "gameId", g.ID, "err", err)
+			}
+		}
+	}
+	sort.Strings(reaped)
+	return reaped
+}
+
+//Run reaps finished games on the given interval until the context is done
+func (l *Lobby) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			l.Reap(now)
+		}
+	}
+}
+
+//Close shuts down every game in the lobby, and returns the errors they closed
+//with
+func (l *Lobby) Close() error {
+	l.m.Lock()
+	games := l.games
+	l.games = make(map[string]*SecretHitler)
+	l.finished = make(map[string]time.Time)
+	l.m.Unlock()
+	var errs []error
+	for _, g := range games {
+		errs = append(errs, g.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package sh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLobbyCreateAndJoin(t *testing.T) {
+	l := NewLobby(time.Minute)
+	defer l.Close()
+	g, err := l.CreateGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	id := g.FilteredGame(ctx).ID
+	if id == "" {
+		t.Fatal("Expected the game to be assigned an id")
+	}
+	if _, err := l.Join(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	open := l.OpenGames(ctx)
+	if len(open) != 1 || len(open[0].Players) != 1 {
+		t.Fatal("Expected one open game with one player", open)
+	}
+	//Quick join should land in the same game
+	ctx2 := WithViewer(context.Background(), PlayerViewer("2"))
+	g2, err := l.QuickJoin(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g2 != g {
+		t.Fatal("Expected quick join to use the open game")
+	}
+}
+
+func TestLobbyReap(t *testing.T) {
+	l := NewLobby(time.Minute)
+	defer l.Close()
+	g, err := l.CreateGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actx := WithViewer(context.Background(), AdminViewer())
+	id := g.FilteredGame(actx).ID
+	err = g.SubmitEvent(actx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{State: GameStateFinished},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if reaped := l.Reap(now); len(reaped) != 0 {
+		t.Fatal("Game should be retained after finishing", reaped)
+	}
+	if reaped := l.Reap(now.Add(2 * time.Minute)); len(reaped) != 1 || reaped[0] != id {
+		t.Fatal("Game should be reaped after the retention period", reaped)
+	}
+	if _, err := l.GetGame(id); err == nil {
+		t.Fatal("Reaped game should be gone")
+	}
+}
+
+//forgetStore records the games it was asked to forget
+type forgetStore struct {
+	memStore
+	forgotten []string
+}
+
+func (s *forgetStore) Forget(gameID string) error {
+	s.forgotten = append(s.forgotten, gameID)
+	return nil
+}
+
+func TestLobbyReapForgets(t *testing.T) {
+	l := NewLobby(0)
+	defer l.Close()
+	fs := new(forgetStore)
+	l.Store = fs
+	g, err := l.CreateGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actx := WithViewer(context.Background(), AdminViewer())
+	id := g.FilteredGame(actx).ID
+	err = g.SubmitEvent(actx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{State: GameStateFinished},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped := l.Reap(time.Now()); len(reaped) != 1 || len(fs.forgotten) != 1 || fs.forgotten[0] != id {
+		t.Fatal("Expected the store to forget the reaped game", reaped, fs.forgotten)
+	}
+}
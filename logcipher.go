@@ -0,0 +1,198 @@
+package sh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	cr "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//LogCipher encrypts an event log at rest with AES-GCM. Each line of the log is
+//sealed on its own, with a random nonce, so a log can still be appended to and
+//read line by line. The number of the line is sealed with it, so a line that
+//was changed, moved, dropped or repeated, or sealed with another key, can't be
+//opened. Lines cut off the end of a log can't be told apart from a shorter log.
+type LogCipher struct {
+	aead cipher.AEAD
+}
+
+//NewLogCipher returns a cipher for the key, which must be 16, 24 or 32 bytes
+//to pick AES-128, AES-192 or AES-256
+func NewLogCipher(key []byte) (*LogCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &LogCipher{aead: aead}, nil
+}
+
+//Seal encrypts the plaintext bound to the associated data, which has to be
+//given again to open it, returning it base64 encoded
+func (c *LogCipher) Seal(plain, ad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := cr.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, ad)
+	ret := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(ret, sealed)
+	return ret, nil
+}
+
+//Open decrypts what Seal returned for the same associated data
+func (c *LogCipher) Open(sealed, ad []byte) ([]byte, error) {
+	b := make([]byte, base64.StdEncoding.DecodedLen(len(sealed)))
+	n, err := base64.StdEncoding.Decode(b, sealed)
+	if err != nil {
+		return nil, errors.New("Data is not encrypted")
+	}
+	b = b[:n]
+	ns := c.aead.NonceSize()
+	if len(b) < ns {
+		return nil, errors.New("Data could not be decrypted")
+	}
+	plain, err := c.aead.Open(nil, b[:ns], b[ns:], ad)
+	if err != nil {
+		return nil, errors.New("Data could not be decrypted")
+	}
+	return plain, nil
+}
+
+//lineAD is the associated data of the line with the number in a log
+func lineAD(n uint64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, n)
+	return ad
+}
+
+type logEncrypter struct {
+	w io.Writer
+	c *LogCipher
+	m sync.Mutex
+	//line is the number of the next line, shared with the decrypter of a log
+	//that is read before it is appended to
+	line *uint64
+	buf  []byte
+}
+
+//EncryptLog returns a writer, to use as a games Log, that seals each line
+//written to it before passing it on to w. It starts a new log, use EncryptedLog
+//to append to one. Flush and Close are passed on to w.
+func EncryptLog(w io.Writer, c *LogCipher) io.WriteCloser {
+	return &logEncrypter{w: w, c: c, line: new(uint64)}
+}
+
+//Write seals every whole line and writes them on. Nothing is kept if it fails.
+func (le *logEncrypter) Write(p []byte) (int, error) {
+	le.m.Lock()
+	defer le.m.Unlock()
+	buf := append(le.buf[:len(le.buf):len(le.buf)], p...)
+	line := *le.line
+	out := []byte{}
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		sealed, err := le.c.Seal(buf[:i], lineAD(line))
+		if err != nil {
+			return 0, err
+		}
+		out = append(append(out, sealed...), '\n')
+		buf = buf[i+1:]
+		line++
+	}
+	if len(out) > 0 {
+		if _, err := le.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	le.buf = append([]byte(nil), buf...)
+	*le.line = line
+	return len(p), nil
+}
+
+//Flush flushes w, if it buffers
+func (le *logEncrypter) Flush() error {
+	if f, ok := le.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+//Close closes w, if it can be, and fails if a line was left unfinished
+func (le *logEncrypter) Close() error {
+	var errs []error
+	le.m.Lock()
+	if len(le.buf) > 0 {
+		errs = append(errs, errors.New("Event log ends in an unfinished line"))
+	}
+	le.m.Unlock()
+	if c, ok := le.w.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type logDecrypter struct {
+	r    *bufio.Reader
+	c    *LogCipher
+	line *uint64
+	buf  []byte
+}
+
+//DecryptLog returns a reader of the plain log sealed in r by EncryptLog, for
+//ReadEventLog, LoadSecretHitler or VerifyLog to read. Reading fails at the first
+//line that can't be opened.
+func DecryptLog(r io.Reader, c *LogCipher) io.Reader {
+	return &logDecrypter{r: bufio.NewReader(r), c: c, line: new(uint64)}
+}
+
+func (ld *logDecrypter) Read(p []byte) (int, error) {
+	for len(ld.buf) == 0 {
+		line, err := ld.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			open, oerr := ld.c.Open(line, lineAD(*ld.line))
+			if oerr != nil {
+				return 0, fmt.Errorf("Event log line %d: %w", *ld.line+1, oerr)
+			}
+			*ld.line++
+			ld.buf = append(open, '\n')
+		}
+		if err != nil && len(ld.buf) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, ld.buf)
+	ld.buf = ld.buf[n:]
+	return n, nil
+}
+
+type encryptedLog struct {
+	*logDecrypter
+	*logEncrypter
+}
+
+//EncryptedLog wraps a log that is both read and appended to, such as a file
+//given to LoadSecretHitler, decrypting what is read and encrypting what is
+//written. The log has to be read to its end before it is appended to, so the
+//new lines carry on from the last one. Flush and Close are passed on to rw.
+func EncryptedLog(rw io.ReadWriter, c *LogCipher) io.ReadWriteCloser {
+	line := new(uint64)
+	return encryptedLog{
+		&logDecrypter{r: bufio.NewReader(rw), c: c, line: line},
+		&logEncrypter{w: rw, c: c, line: line},
+	}
+}
@@ -0,0 +1,134 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedLog(t *testing.T) {
+	c, err := NewLogCipher([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := NewSecretHitler()
+	buf := new(bytes.Buffer)
+	sh.Log = EncryptLog(buf, c)
+	sh.Chain = &Chain{}
+	for i := 1; i <= 5; i++ {
+		id := strconv.Itoa(i)
+		ctx := WithViewer(context.Background(), PlayerViewer(id))
+		err := sh.SubmitEvent(ctx, PlayerEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerJoin},
+			Player:    Player{ID: id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh.Close()
+	log := buf.String()
+	if strings.Contains(log, TypePlayerJoin) {
+		t.Fatal("Expected the log to be encrypted", log)
+	}
+
+	//The log can be appended to after it is loaded
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(log), buf}
+	lsh, err := LoadSecretHitler(EncryptedLog(rw, c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsh.Close()
+	if lsh.Game.EventID != 5 {
+		t.Fatal("Expected the events to be restored", lsh.Game.EventID)
+	}
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	if err = lsh.SubmitEvent(ctx, PlayerEvent{BaseEvent: BaseEvent{Type: TypePlayerReady}, Player: Player{ID: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyLog(DecryptLog(bytes.NewReader(buf.Bytes()), c), nil); err != nil || n != 6 {
+		t.Fatal("Expected the encrypted log to verify", n, err)
+	}
+
+	//A changed line or the wrong key can't be opened
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if lines[2][0] == 'A' {
+		lines[2] = "B" + lines[2][1:]
+	} else {
+		lines[2] = "A" + lines[2][1:]
+	}
+	if _, err := VerifyLog(DecryptLog(strings.NewReader(strings.Join(lines, "")), c), nil); err == nil {
+		t.Fatal("Expected a changed line to be refused")
+	}
+	other, _ := NewLogCipher([]byte("fedcba9876543210fedcba9876543210"))
+	if _, err := VerifyLog(DecryptLog(bytes.NewReader(buf.Bytes()), other), nil); err == nil {
+		t.Fatal("Expected the wrong key to be refused")
+	}
+}
+
+//failWriter fails every write until it is told not to, and counts its closes
+type failWriter struct {
+	bytes.Buffer
+	fail   bool
+	closed int
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("disk full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *failWriter) Close() error { w.closed++; return nil }
+
+func TestEncryptedLogLines(t *testing.T) {
+	c, err := NewLogCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(failWriter)
+	le := EncryptLog(w, c)
+	for i := 1; i <= 4; i++ {
+		if _, err := io.WriteString(le, "line "+strconv.Itoa(i)+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//A failed write leaves nothing behind, so it can be tried again
+	w.fail = true
+	if _, err := io.WriteString(le, "line 5\n"); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	w.fail = false
+	if _, err := io.WriteString(le, "line 5\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := le.Close(); err != nil || w.closed != 1 {
+		t.Fatal("Expected closing to close the writer", err, w.closed)
+	}
+	read := func(lines []string) (string, error) {
+		b, err := io.ReadAll(DecryptLog(strings.NewReader(strings.Join(lines, "")), c))
+		return string(b), err
+	}
+	lines := strings.SplitAfter(w.String(), "\n")
+	lines = lines[:len(lines)-1]
+	if s, err := read(lines); err != nil || s != "line 1\nline 2\nline 3\nline 4\nline 5\n" {
+		t.Fatal("Expected every line back once", s, err)
+	}
+
+	moved := []string{lines[0], lines[2], lines[1], lines[3], lines[4]}
+	dropped := []string{lines[0], lines[1], lines[3], lines[4]}
+	repeated := []string{lines[0], lines[1], lines[1], lines[2], lines[3], lines[4]}
+	for name, ls := range map[string][]string{"moved": moved, "dropped": dropped, "repeated": repeated} {
+		if _, err := read(ls); err == nil {
+			t.Fatal("Expected a log with a line "+name+" to be refused", ls)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package sh
+
+import (
+	"log/slog"
+	"time"
+)
+
+//logger is the Logger of the game, or the default logger if it has none
+func (sh *SecretHitler) logger() *slog.Logger {
+	if sh.Logger != nil {
+		return sh.Logger
+	}
+	return slog.Default()
+}
+
+//eventAttrs are the structured fields logged for an event of the game
+func eventAttrs(gameID string, e Event) []any {
+	attrs := []any{"gameId", gameID, "eventId", e.GetID(), "eventType", e.GetType()}
+	if pid := eventPlayerID(e); pid != "" {
+		attrs = append(attrs, "playerId", pid)
+	}
+	return attrs
+}
+
+//eventPlayerID is the player the event is from or for, if any
+func eventPlayerID(e Event) string {
+	switch te := e.(type) {
+	case PlayerEvent:
+		return te.Player.ID
+	case PlayerPlayerEvent:
+		return te.PlayerID
+	case PlayerVoteEvent:
+		return te.PlayerID
+	case PlayerLegislateEvent:
+		return te.PlayerID
+	case MessageEvent:
+		return te.PlayerID
+	case InformationEvent:
+		return te.PlayerID
+	case RequestEvent:
+		return te.PlayerID
+	case TimeoutEvent:
+		return te.PlayerID
+	case ReactEvent:
+		return te.PlayerID
+	case AssertEvent:
+		return te.PlayerID
+	case GuessEvent:
+		return te.PlayerID
+	case ErrorEvent:
+		return te.PlayerID
+	}
+	return ""
+}
+
+//reportError logs that the engine failed to respond to an event, or that the
+//event it generated in response was refused, and sends an ErrorEvent to the
+//admin subscribers
+func (sh *SecretHitler) reportError(g Game, cause Event, refused Event, err error) {
+	ee := ErrorEvent{
+		BaseEvent: BaseEvent{Type: TypeGameError},
+		EventID:   cause.GetID(),
+		EventType: cause.GetType(),
+		PlayerID:  eventPlayerID(cause),
+		Error:     err.Error(),
+	}
+	msg := "engine failed"
+	if refused != nil {
+		msg = "engine event refused"
+		ee.RefusedType = refused.GetType()
+		if pid := eventPlayerID(refused); pid != "" {
+			ee.PlayerID = pid
+		}
+	}
+	attrs := []any{"gameId", g.ID, "eventId", ee.EventID, "eventType", ee.EventType}
+	if ee.RefusedType != "" {
+		attrs = append(attrs, "refusedType", ee.RefusedType)
+	}
+	if ee.PlayerID != "" {
+		attrs = append(attrs, "playerId", ee.PlayerID)
+	}
+	sh.logger().Error(msg, append(attrs, "err", err)...)
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	ee.ID = sh.Game.EventID
+	ee.Moment = time.Now()
+	sh.broadcast(ee)
+}
@@ -0,0 +1,118 @@
+package sh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineErrorReported(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	buf := new(bytes.Buffer)
+	sh.Logger = slog.New(slog.NewJSONHandler(buf, nil))
+	sh.Store = new(memStore)
+	sh.CheckInvariants = InvariantsReject
+	admin, err := sh.Subscribe(context.Background(), AdminViewer(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, err := sh.Subscribe(context.Background(), PlayerViewer("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make(chan Event, 10)
+	sh.AddSubscriber("raw", raw)
+
+	//The engine starts the game for a snapshot with everyone ready, but the
+	//game itself has enacted policies it never dealt, so the start is refused
+	g := sh.Game
+	for i := 1; i <= 5; i++ {
+		g.Players = append(g.Players, Player{ID: strconv.Itoa(i), Ready: true})
+	}
+	ready := PlayerEvent{BaseEvent: BaseEvent{ID: 1, Type: TypePlayerReady}, Player: Player{ID: "5"}}
+	sh.m.Lock()
+	sh.Game.Liberal = 3
+	sh.queueEngine(ready, g)
+	sh.m.Unlock()
+
+	select {
+	case e := <-admin.Events():
+		ee, ok := e.(ErrorEvent)
+		if !ok || ee.EventID != 1 || ee.EventType != TypePlayerReady || ee.RefusedType != TypeGameUpdate || ee.PlayerID != "5" || !strings.Contains(ee.Error, "invariants") {
+			t.Fatal("Expected the refused event to be reported", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the admin to be sent the error")
+	}
+	//The rest of what the engine sent is still sent to everyone
+	timeout := time.After(50 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case e := <-player.Events():
+			if _, ok := e.(ErrorEvent); ok {
+				t.Fatal("Expected players not to be sent errors", e)
+			}
+		case e := <-raw:
+			if _, ok := e.(ErrorEvent); ok {
+				t.Fatal("Expected subscribers that aren't admins not to be sent errors", e)
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+
+	admin.Close()
+	player.Close()
+	sh.RemoveSubscriber("raw")
+	sh.Close()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	found := false
+	for _, l := range lines {
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatal(err, l)
+		}
+		if m["msg"] == "engine event refused" {
+			found = m["level"] == "ERROR" && m["eventId"] == 1.0 && m["eventType"] == TypePlayerReady && m["refusedType"] == TypeGameUpdate && m["playerId"] == "5"
+		}
+	}
+	if !found {
+		t.Fatal("Expected the refused event to be logged with its fields", lines)
+	}
+}
+
+func TestErrorEvent(t *testing.T) {
+	ee := ErrorEvent{
+		BaseEvent: BaseEvent{ID: 3, Type: TypeGameError},
+		EventID:   2,
+		EventType: TypePlayerVote,
+		PlayerID:  "1",
+		Error:     "Players can only vote once per round",
+	}
+	b, err := json.Marshal(ee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := UnmarshalEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ue, ok := e.(ErrorEvent); !ok || ue.EventID != 2 || ue.Error != ee.Error {
+		t.Fatal("Expected the error event to round trip", e)
+	}
+	if fe := ee.Filter(WithViewer(context.Background(), PlayerViewer("1"))).(ErrorEvent); fe.Error != "masked" {
+		t.Fatal("Expected the error to be masked from players", fe)
+	}
+	if fe := ee.Filter(WithViewer(context.Background(), AdminViewer())).(ErrorEvent); fe.Error != ee.Error {
+		t.Fatal("Expected the admin to see the error", fe)
+	}
+	if err := (Game{}).Validate(WithViewer(context.Background(), AdminViewer()), ee); err == nil {
+		t.Fatal("Expected errors to be refused as events")
+	}
+}
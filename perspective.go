@@ -0,0 +1,76 @@
+package sh
+
+import (
+	"context"
+	"io"
+)
+
+//Perspective is what a single player knew over the course of a game. Step i is
+//the player's view right after the event with id i+1.
+type Perspective struct {
+	Viewer Viewer            `json:"viewer"`
+	Steps  []PerspectiveStep `json:"steps"`
+}
+
+//PerspectiveStep is the event as the player received it, and the game as it
+//was filtered for them once the event was applied
+type PerspectiveStep struct {
+	Event Event `json:"event"`
+	Game  Game  `json:"game"`
+}
+
+//NewPerspective replays the events of a game through the filters of the viewer,
+//usually a player but a spectator works as well, to rebuild exactly what they
+//were shown at every event.
+func NewPerspective(events []Event, v Viewer) (Perspective, error) {
+	ret := Perspective{Viewer: v}
+	ctx := WithViewer(context.Background(), v)
+	g := Game{}
+	var err error
+	for _, e := range events {
+		g, err = g.Replay(e)
+		if err != nil {
+			return ret, err
+		}
+		ret.Steps = append(ret.Steps, PerspectiveStep{
+			Event: e.Filter(ctx),
+			Game:  g.Filter(ctx),
+		})
+	}
+	return ret, nil
+}
+
+//ReadPerspective rebuilds the perspective of the viewer from an event log
+//written by SubmitEvent
+func ReadPerspective(r io.Reader, v Viewer) (Perspective, error) {
+	events, err := readEvents(r)
+	if err != nil {
+		return Perspective{}, err
+	}
+	return NewPerspective(events, v)
+}
+
+//At returns the game as the player saw it right after the event. Before the
+//first event, or past the last, it returns false.
+func (p Perspective) At(eventID int) (Game, bool) {
+	if eventID < 1 || eventID > len(p.Steps) {
+		return Game{}, false
+	}
+	return p.Steps[eventID-1].Game, true
+}
+
+//Received returns every event the player had received up to and including the
+//event
+func (p Perspective) Received(eventID int) []Event {
+	if eventID > len(p.Steps) {
+		eventID = len(p.Steps)
+	}
+	ret := []Event{}
+	if eventID < 1 {
+		return ret
+	}
+	for _, s := range p.Steps[:eventID] {
+		ret = append(ret, s.Event)
+	}
+	return ret
+}
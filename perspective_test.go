@@ -0,0 +1,58 @@
+package sh
+
+import (
+	"testing"
+)
+
+func TestPerspective(t *testing.T) {
+	start := startedGame()
+	start.Round.State = RoundStateLegislating
+	start.Round.ChancellorID = "2"
+	start.Round.Policies = start.Draw[:3]
+	start.Draw = start.Draw[3:]
+	events := []Event{}
+	g := Game{}
+	for _, e := range []Event{
+		GameEvent{BaseEvent: BaseEvent{Type: TypeGameUpdate}, Game: start},
+		RequestEvent{BaseEvent: BaseEvent{Type: TypeRequestLegislate}, PlayerID: "1", RoundID: 1, Policies: start.Round.Policies},
+		PlayerLegislateEvent{BaseEvent: BaseEvent{Type: TypePlayerLegislate}, PlayerID: "1", Discard: PolicyLiberal},
+	} {
+		var ne Event
+		var err error
+		g, ne, err = g.Apply(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ne)
+	}
+
+	p, err := NewPerspective(events, PlayerViewer("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg, ok := p.At(1)
+	if !ok {
+		t.Fatal("Expected a view of the first event")
+	}
+	if fg.Players[2].Role != RoleLiberal || fg.Players[4].Role != RoleMasked {
+		t.Fatal("Expected the player to only know their own role", fg.Players)
+	}
+	if fg.Round.Policies[0] != PolicyMasked {
+		t.Fatal("Expected the round policies to be masked", fg.Round.Policies)
+	}
+	received := p.Received(3)
+	if len(received) != 3 {
+		t.Fatal("Expected every event to be received", len(received))
+	}
+	if received[1].(RequestEvent).Policies[0] != PolicyMasked {
+		t.Fatal("Expected the request to be masked")
+	}
+	if _, ok := p.At(4); ok || len(p.Received(0)) != 0 {
+		t.Fatal("Expected nothing outside of the log")
+	}
+
+	p, _ = NewPerspective(events, PlayerViewer("1"))
+	if p.Steps[1].Event.(RequestEvent).Policies[0] != PolicyLiberal {
+		t.Fatal("Expected the president to see the request")
+	}
+}
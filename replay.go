@@ -0,0 +1,187 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	//AnnotationLie marks an assertion that doesn't match what the player was shown
+	AnnotationLie = "lie"
+	//AnnotationTruth marks an assertion that matches what the player was shown
+	AnnotationTruth = "truth"
+	//AnnotationFascistVote marks a vote cast by a member of the fascist party
+	AnnotationFascistVote = "fascist_vote"
+	//AnnotationForcedDiscard marks a discard from a hand of identical policies,
+	//where the player had no choice to make
+	AnnotationForcedDiscard = "forced_discard"
+)
+
+//Annotation is a note on an event that only makes sense once the whole game is
+//known, such as a player lying about the policies they were dealt
+type Annotation struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerId"`
+	Note     string `json:"note"`
+}
+
+//ReplayStep is a single event of a finished game. Event is the true content of
+//the event, but for the signing secrets of the game, and Views is the event as
+//it was sent to each player and to the public spectators at the time.
+type ReplayStep struct {
+	Event       Event            `json:"event"`
+	Views       map[string]Event `json:"views"`
+	Annotations []Annotation     `json:"annotations,omitempty"`
+}
+
+//Replay is the annotated record of a finished game
+type Replay struct {
+	Game  Game         `json:"game"`
+	Steps []ReplayStep `json:"steps"`
+}
+
+//NewReplay walks the events of a game and annotates each one. Only a finished
+//game can be replayed, as the replay reveals everything but the secrets the
+//game signs its tokens with, which would let anyone forge them.
+func NewReplay(events []Event) (Replay, error) {
+	ret := Replay{}
+	g := Game{}
+	var err error
+	//The game as it was before each event
+	before := make([]Game, len(events))
+	for i, e := range events {
+		before[i] = g
+		g, err = g.Replay(e)
+		if err != nil {
+			return ret, err
+		}
+	}
+	if g.State != GameStateFinished {
+		return ret, errors.New("Game is not finished")
+	}
+	//The omniscient view is everything but the signing secrets
+	all := WithViewer(context.Background(), OmniscientViewer())
+	ret.Game = g.Filter(all)
+
+	viewers := []Viewer{SpectatorViewer()}
+	for _, p := range g.Players {
+		viewers = append(viewers, PlayerViewer(p.ID))
+	}
+	//What each player was shown, to check their assertions against
+	shown := map[string][]string{}
+	parties := map[string]string{}
+
+	for i, e := range events {
+		step := ReplayStep{Event: e.Filter(all), Views: map[string]Event{}}
+		for _, v := range viewers {
+			step.Views[v.ID()] = e.Filter(WithViewer(context.Background(), v))
+		}
+		note := func(t, playerID, format string, a ...interface{}) {
+			step.Annotations = append(step.Annotations, Annotation{Type: t, PlayerID: playerID, Note: fmt.Sprintf(format, a...)})
+		}
+
+		switch te := e.(type) {
+		case RequestEvent:
+			if te.Type == TypeRequestLegislate && len(te.Policies) > 0 {
+				shown[shownKey(te.PlayerID, te.RoundID, TypeRequestLegislate)] = te.Policies
+			}
+		case InformationEvent:
+			if te.OtherPlayerID != "" {
+				parties[shownKey(te.PlayerID, te.RoundID, te.OtherPlayerID)] = te.Party
+			} else {
+				shown[shownKey(te.PlayerID, te.RoundID, ExecutiveActionPeek)] = te.Policies
+			}
+		case PlayerVoteEvent:
+			if p, _ := g.GetPlayerByID(te.PlayerID); p.Party == PartyFascist {
+				vote := "against"
+				if te.Vote {
+					vote = "for"
+				}
+				note(AnnotationFascistVote, te.PlayerID, "Player %s is a %s and voted %s the government", te.PlayerID, p.Role, vote)
+			}
+		case PlayerLegislateEvent:
+			if hand := before[i].Round.Policies; !te.Veto && len(hand) > 1 && identical(hand) {
+				note(AnnotationForcedDiscard, te.PlayerID, "Player %s was dealt %s and had to discard a %s policy", te.PlayerID, strings.Join(hand, ", "), te.Discard)
+			}
+		case AssertEvent:
+			if te.Type == TypeAssertParty {
+				truth, ok := parties[shownKey(te.PlayerID, te.RoundID, te.OtherPlayerID)]
+				if !ok {
+					break
+				}
+				if truth == te.Party {
+					note(AnnotationTruth, te.PlayerID, "Player %s truthfully claimed player %s is %s", te.PlayerID, te.OtherPlayerID, te.Party)
+				} else {
+					note(AnnotationLie, te.PlayerID, "Player %s claimed player %s is %s, but was shown %s", te.PlayerID, te.OtherPlayerID, te.Party, truth)
+				}
+				break
+			}
+			truth, ok := shown[shownKey(te.PlayerID, te.RoundID, te.PolicySource)]
+			if !ok {
+				break
+			}
+			if samePolicies(truth, te.Policies) {
+				note(AnnotationTruth, te.PlayerID, "Player %s truthfully claimed %s", te.PlayerID, strings.Join(te.Policies, ", "))
+			} else {
+				note(AnnotationLie, te.PlayerID, "Player %s claimed %s, but was shown %s", te.PlayerID, strings.Join(te.Policies, ", "), strings.Join(truth, ", "))
+			}
+		}
+		ret.Steps = append(ret.Steps, step)
+	}
+	return ret, nil
+}
+
+//ReadReplay replays a finished game from an event log written by SubmitEvent
+func ReadReplay(r io.Reader) (Replay, error) {
+	events, err := readEvents(r)
+	if err != nil {
+		return Replay{}, err
+	}
+	return NewReplay(events)
+}
+
+//LoadReplay replays a finished game from the events kept in the store
+func LoadReplay(s EventStore, gameID string) (Replay, error) {
+	events, err := s.LoadFrom(gameID, 0)
+	if err != nil {
+		return Replay{}, err
+	}
+	if len(events) == 0 {
+		return Replay{}, errors.New("Game not found")
+	}
+	return NewReplay(events)
+}
+
+func shownKey(playerID string, roundID int, source string) string {
+	return fmt.Sprintf("%s/%d/%s", playerID, roundID, source)
+}
+
+func identical(policies []string) bool {
+	for _, p := range policies {
+		if p != policies[0] {
+			return false
+		}
+	}
+	return true
+}
+
+//samePolicies compares two hands regardless of the order of the policies
+func samePolicies(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
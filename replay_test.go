@@ -0,0 +1,82 @@
+package sh
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestReplayAnnotations(t *testing.T) {
+	start := startedGame()
+	start.Round.State = RoundStateLegislating
+	start.Round.ChancellorID = "4"
+	start.Round.Policies = []string{PolicyFascist, PolicyFascist, PolicyFascist}
+	start.Draw = start.Draw[3:]
+	start.Keys = []TokenKey{testTokenKey(t)}
+	events := []Event{
+		GameEvent{BaseEvent: BaseEvent{Type: TypeGameUpdate}, Game: start},
+		PlayerVoteEvent{BaseEvent: BaseEvent{Type: TypePlayerVote}, PlayerID: "4", Vote: true},
+		PlayerVoteEvent{BaseEvent: BaseEvent{Type: TypePlayerVote}, PlayerID: "1", Vote: true},
+		RequestEvent{BaseEvent: BaseEvent{Type: TypeRequestLegislate}, PlayerID: "1", RoundID: 1, Policies: start.Round.Policies},
+		PlayerLegislateEvent{BaseEvent: BaseEvent{Type: TypePlayerLegislate}, PlayerID: "1", Discard: PolicyFascist},
+		AssertEvent{BaseEvent: BaseEvent{Type: TypeAssertPolicies}, PlayerID: "1", RoundID: 1, PolicySource: TypeRequestLegislate, Policies: []string{PolicyFascist, PolicyFascist, PolicyFascist}},
+		AssertEvent{BaseEvent: BaseEvent{Type: TypeAssertPolicies}, PlayerID: "1", RoundID: 1, PolicySource: TypeRequestLegislate, Policies: []string{PolicyLiberal, PolicyFascist, PolicyFascist}},
+		FinishedEvent{BaseEvent: BaseEvent{Type: TypeGameFinished}, WinningParty: PartyFascist},
+		GameEvent{BaseEvent: BaseEvent{Type: TypeGameUpdate}, Game: Game{State: GameStateFinished, WinningParty: PartyFascist}},
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	g := Game{}
+	for _, e := range events {
+		var ne Event
+		var err error
+		g, ne, err = g.Apply(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.Encode(ne)
+	}
+	if _, err := NewReplay(events[:1]); err == nil {
+		t.Fatal("Expected a game in progress to be refused")
+	}
+
+	r, err := ReadReplay(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Steps) != len(events) {
+		t.Fatal("Expected a step for every event", len(r.Steps))
+	}
+	want := map[int]string{
+		1: AnnotationFascistVote,
+		4: AnnotationForcedDiscard,
+		5: AnnotationTruth,
+		6: AnnotationLie,
+	}
+	for i, s := range r.Steps {
+		if want[i] == "" && len(s.Annotations) > 0 {
+			t.Fatal("Expected no annotations on step", i, s.Annotations)
+		}
+		if want[i] != "" && (len(s.Annotations) != 1 || s.Annotations[0].Type != want[i]) {
+			t.Fatal("Expected annotation on step", i, want[i], s.Annotations)
+		}
+	}
+
+	//The request was only shown to the president
+	if p := r.Steps[3].Views["2"].(RequestEvent).Policies[0]; p != PolicyMasked {
+		t.Fatal("Expected the other players view to be masked", p)
+	}
+	if p := r.Steps[3].Views["1"].(RequestEvent).Policies[0]; p != PolicyFascist {
+		t.Fatal("Expected the presidents view to show the policies", p)
+	}
+	if p := r.Steps[3].Event.(RequestEvent).Policies[0]; p != PolicyFascist {
+		t.Fatal("Expected the true event to be unmasked", p)
+	}
+	//Anyone can fetch a replay, so it mustn't let them sign tokens
+	if k := r.Game.Keys[0]; k.Secret != "" || k.Public == "" {
+		t.Fatal("Expected the replay to only show the public key", k)
+	}
+	if k := r.Steps[0].Event.(GameEvent).Game.Keys[0]; k.Secret != "" {
+		t.Fatal("Expected the true event to leave out the key secret", k)
+	}
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/auth"
+)
+
+//Server hosts the games of a lobby over http. Events are posted to the game,
+//the filtered game state can be fetched, and each player can open a websocket
+//to receive every broadcast event filtered for them. Spectators can open a
+//websocket to watch a game without joining it.
+type Server struct {
+	Lobby    *sh.Lobby
+	Upgrader websocket.Upgrader
+	//Auth, if set, requires every player to log in and send their session token.
+	//Without it the player is whoever the request claims to be.
+	Auth *auth.Authenticator
+	//OmniscientDelayEvents and OmniscientDelayTime hold back the events sent to
+	//omniscient spectators. The omniscient view is refused unless one is set,
+	//and Auth is set so that the players of the game can be refused it.
+	OmniscientDelayEvents int
+	OmniscientDelayTime   time.Duration
+
+	mux *http.ServeMux
+}
+
+func NewServer(lobby *sh.Lobby) *Server {
+	s := new(Server)
+	s.Lobby = lobby
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /auth/register", s.handleRegister)
+	s.mux.HandleFunc("POST /auth/login", s.handleLogin)
+	s.mux.HandleFunc("POST /auth/logout", s.handleLogout)
+	s.mux.HandleFunc("GET /games", s.handleOpenGames)
+	s.mux.HandleFunc("GET /games/mine", s.handlePlayerGames)
+	s.mux.HandleFunc("POST /games", s.handleCreateGame)
+	s.mux.HandleFunc("POST /games/join", s.handleQuickJoin)
+	s.mux.HandleFunc("GET /games/{id}", s.handleGetGame)
+	s.mux.HandleFunc("POST /games/{id}/events", s.handlePostEvent)
+	s.mux.HandleFunc("GET /games/{id}/ws", s.handleWebSocket)
+	s.mux.HandleFunc("GET /games/{id}/spectate", s.handleSpectate)
+	s.mux.HandleFunc("GET /games/{id}/replay", s.handleReplay)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Auth != nil {
+		s.Auth.Middleware(s.mux).ServeHTTP(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+//playerContext returns the context of a request from a player. With Auth set
+//the auth middleware has already placed the player from their session token
+//into it. Otherwise the callers player id is pulled off of the request and
+//placed into the context, the reserved engine and admin ids can't be claimed by
+//a client.
+func (s *Server) playerContext(r *http.Request) (context.Context, error) {
+	if v := sh.ViewerFrom(r.Context()); v.Kind == sh.ViewerPlayer {
+		return r.Context(), nil
+	}
+	if s.Auth != nil {
+		return nil, errors.New("Player not authenticated")
+	}
+	pid := r.Header.Get("X-Player-ID")
+	if pid == "" {
+		pid = r.URL.Query().Get("playerId")
+	}
+	if pid == "" {
+		return nil, errors.New("Player not authenticated")
+	}
+	if sh.IsReservedID(pid) {
+		return nil, errors.New("Reserved player id")
+	}
+	return sh.WithViewer(r.Context(), sh.PlayerViewer(pid)), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+type credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+//session replies with a new session token and the player it is for
+func (s *Server) session(w http.ResponseWriter, token string) {
+	c, err := s.Auth.Verify(token)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"token": token, "playerId": c.PlayerID})
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil {
+		writeError(w, http.StatusNotFound, errors.New("Accounts are not enabled"))
+		return
+	}
+	c := credentials{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<12)).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	token, err := s.Auth.Register(c.Name, c.Password)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.session(w, token)
+}
+
+//handleLogin starts a new session. Logging in again resumes the same player id,
+//so a player that lost their token can reconnect to the games they are in.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil {
+		writeError(w, http.StatusNotFound, errors.New("Accounts are not enabled"))
+		return
+	}
+	c := credentials{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<12)).Decode(&c); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	token, err := s.Auth.Login(c.Name, c.Password)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	s.session(w, token)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil {
+		writeError(w, http.StatusNotFound, errors.New("Accounts are not enabled"))
+		return
+	}
+	if err := s.Auth.Revoke(auth.Token(r)); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePlayerGames(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Lobby.PlayerGames(ctx))
+}
+
+func (s *Server) handleCreateGame(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	g, err := s.Lobby.CreateGame()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, g.FilteredGame(ctx))
+}
+
+func (s *Server) handleOpenGames(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Lobby.OpenGames(ctx))
+}
+
+func (s *Server) handleQuickJoin(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	g, err := s.Lobby.QuickJoin(ctx)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g.FilteredGame(ctx))
+}
+
+func (s *Server) handleGetGame(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	g, err := s.Lobby.GetGame(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g.FilteredGame(ctx))
+}
+
+func (s *Server) handlePostEvent(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	g, err := s.Lobby.GetGame(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	e, err := sh.UnmarshalEvent(b)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = g.SubmitEvent(ctx, e); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	g, err := s.Lobby.GetGame(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	//A finished game has nothing more to stream, so the client is sent how it
+	//ended
+	if fg := g.FilteredGame(ctx); fg.State == sh.GameStateFinished {
+		conn, err := s.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sendFinished(conn, fg)
+		return
+	}
+	//A client that reconnects resumes from the last event it saw
+	if from := r.URL.Query().Get("from"); from != "" {
+		id, err := strconv.Atoi(from)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sub, err := g.Subscribe(ctx, sh.ViewerFrom(ctx), id)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		conn, err := s.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			sub.Close()
+			return
+		}
+		defer conn.Close()
+		//The subscription events are already filtered
+		stream(conn, g, sub.Key(), sub.Events(), sub.Close, func(e sh.Event) sh.Event { return e })
+		return
+	}
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//The upgrader has already replied to the client
+		return
+	}
+	defer conn.Close()
+
+	key := "ws:" + genID()
+	ec := make(chan sh.Event, 10)
+	g.AddSubscriber(key, ec)
+	if g.Disconnected(key) == nil {
+		//The game finished in the meantime, so the subscriber wasn't added
+		sendFinished(conn, g.FilteredGame(ctx))
+		return
+	}
+	stream(conn, g, key, ec, func() { g.RemoveSubscriber(key) }, func(e sh.Event) sh.Event { return e.Filter(ctx) })
+}
+
+//handleSpectate streams a game to a spectator that hasn't joined it. The view
+//defaults to the public one, ?view=omniscient asks for the delayed view of
+//everything.
+func (s *Server) handleSpectate(w http.ResponseWriter, r *http.Request) {
+	g, err := s.Lobby.GetGame(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	spec := sh.Spectator{}
+	switch r.URL.Query().Get("view") {
+	case "", "public":
+	case "omniscient":
+		spec.Omniscient = true
+		spec.DelayEvents = s.OmniscientDelayEvents
+		spec.DelayTime = s.OmniscientDelayTime
+		if spec.DelayEvents == 0 && spec.DelayTime == 0 {
+			writeError(w, http.StatusForbidden, errors.New("Omniscient spectators are not allowed"))
+			return
+		}
+		if status, err := s.omniscientAllowed(r, g); err != nil {
+			writeError(w, status, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("Unknown spectator view"))
+		return
+	}
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	key := "spectate:" + genID()
+	ec := make(chan sh.Event, 10)
+	if err := g.AddSpectator(key, spec, ec); err != nil {
+		conn.WriteJSON(map[string]string{"error": err.Error()})
+		return
+	}
+	//The spectator events are already filtered
+	stream(conn, g, key, ec, func() { g.RemoveSubscriber(key) }, func(e sh.Event) sh.Event { return e })
+}
+
+//omniscientAllowed checks that the caller of an omniscient view isn't one of the
+//players, who would learn every role once the delay had passed. Only accounts
+//tell the players apart, so the view needs Auth. The game must have started,
+//so nobody can join it after they started watching.
+func (s *Server) omniscientAllowed(r *http.Request, g *sh.SecretHitler) (int, error) {
+	if s.Auth == nil {
+		return http.StatusForbidden, errors.New("Omniscient spectators need accounts to be enabled")
+	}
+	ctx, err := s.playerContext(r)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	fg := g.FilteredGame(ctx)
+	if fg.State == sh.GameStateLobby {
+		return http.StatusForbidden, errors.New("Game has not started")
+	}
+	if _, err := fg.GetPlayerByID(sh.ViewerFrom(ctx).PlayerID); err == nil {
+		return http.StatusForbidden, errors.New("Players can't spectate their own game")
+	}
+	return 0, nil
+}
+
+//handleReplay returns the annotated replay of a finished game from the store,
+//which outlives the game in the lobby
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if s.Lobby.Store == nil {
+		writeError(w, http.StatusNotFound, errors.New("Games are not stored"))
+		return
+	}
+	rp, err := sh.LoadReplay(s.Lobby.Store, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rp)
+}
+
+//stream writes the events of the subscriber with the key to the websocket until
+//either side goes away, and then stops the subscription. A client the game
+//disconnected for falling behind is told so before the websocket is closed.
+func stream(conn *websocket.Conn, g *sh.SecretHitler, key string, ec <-chan sh.Event, stop func(), filter func(sh.Event) sh.Event) {
+	gone := g.Disconnected(key)
+	//The read loop only exists to notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e, ok := <-ec:
+			if !ok {
+				//The game closed the channel it made, either because the
+				//subscriber fell behind or the game was shut down
+				behind := fellBehind(g, key)
+				stop()
+				closeStream(conn, behind)
+				return
+			}
+			if err := conn.WriteJSON(filter(e)); err != nil {
+				stop()
+				return
+			}
+		case <-gone:
+			stop()
+			closeStream(conn, true)
+			return
+		case <-closed:
+			stop()
+			return
+		}
+	}
+}
+
+//sendFinished sends the final state of a finished game, as a game update, and
+//closes the websocket
+func sendFinished(conn *websocket.Conn, g sh.Game) {
+	conn.WriteJSON(sh.GameEvent{
+		BaseEvent: sh.BaseEvent{ID: g.EventID, Type: sh.TypeGameUpdate, Moment: time.Now()},
+		Game:      g,
+	})
+	closeStream(conn, false)
+}
+
+//fellBehind is whether the game disconnected the subscriber for falling behind
+func fellBehind(g *sh.SecretHitler, key string) bool {
+	for _, s := range g.SubscriberStats() {
+		if s.Key == key {
+			return s.Disconnected
+		}
+	}
+	return false
+}
+
+//closeStream closes the websocket cleanly, after telling a client that fell
+//behind why
+func closeStream(conn *websocket.Conn, behind bool) {
+	if behind {
+		conn.WriteJSON(map[string]string{"error": "Disconnected for falling behind"})
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+func genID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/auth"
+	"github.com/murphysean/secrethitler/store"
+)
+
+func request(t *testing.T, method, url, playerID, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Player-ID", playerID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServerJoinAndStream(t *testing.T) {
+	ts := httptest.NewServer(NewServer(sh.NewLobby(time.Minute)))
+	defer ts.Close()
+
+	resp := request(t, "POST", ts.URL+"/games", "1", "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatal("Expected game to be created", resp.StatusCode)
+	}
+	g := sh.Game{}
+	if err := json.NewDecoder(resp.Body).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if g.ID == "" {
+		t.Fatal("Expected game to have an id")
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/games/" + g.ID + "/ws?playerId=1"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//Give the server a moment to register the subscriber
+	time.Sleep(50 * time.Millisecond)
+
+	b, _ := json.Marshal(sh.PlayerEvent{
+		BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+		Player:    sh.Player{ID: "1"},
+	})
+	resp = request(t, "POST", ts.URL+"/games/"+g.ID+"/events", "1", string(b))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatal("Expected join to be accepted", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := sh.UnmarshalEvent(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetType() != sh.TypePlayerJoin {
+		t.Fatal("Expected a join event, got", e.GetType())
+	}
+
+	resp = request(t, "GET", ts.URL+"/games/"+g.ID, "1", "")
+	g = sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&g)
+	resp.Body.Close()
+	if len(g.Players) != 1 || g.Players[0].ID != "1" {
+		t.Fatal("Expected the joined player in the game", g.Players)
+	}
+}
+
+func TestServerResumeStream(t *testing.T) {
+	fs, err := store.NewFileStore(t.TempDir(), store.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	lobby := sh.NewLobby(time.Minute)
+	lobby.Store = fs
+	ts := httptest.NewServer(NewServer(lobby))
+	defer ts.Close()
+
+	resp := request(t, "POST", ts.URL+"/games", "1", "")
+	g := sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&g)
+	resp.Body.Close()
+	for _, id := range []string{"1", "2"} {
+		b, _ := json.Marshal(sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+			Player:    sh.Player{ID: id},
+		})
+		resp = request(t, "POST", ts.URL+"/games/"+g.ID+"/events", id, string(b))
+		resp.Body.Close()
+	}
+
+	//The client saw the game created, and missed both joins
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/games/" + g.ID + "/ws?playerId=1&from=1"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []int{2, 3} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := sh.UnmarshalEvent(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.GetID() != want || e.GetType() != sh.TypePlayerJoin {
+			t.Fatal("Expected the missed joins replayed in order", want, e.GetID(), e.GetType())
+		}
+	}
+
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL[:len(wsURL)-1]+"9", nil)
+	if err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("Expected resuming from an unknown event to be refused", err)
+	}
+}
+
+func TestServerRejectsReservedIDs(t *testing.T) {
+	ts := httptest.NewServer(NewServer(sh.NewLobby(time.Minute)))
+	defer ts.Close()
+	resp := request(t, "POST", ts.URL+"/games", sh.PlayerIDEngine, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected engine id to be rejected", resp.StatusCode)
+	}
+	resp = request(t, "POST", ts.URL+"/games/nope/events", "1", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("Expected missing game", resp.StatusCode)
+	}
+}
+
+func TestServerQuickJoin(t *testing.T) {
+	ts := httptest.NewServer(NewServer(sh.NewLobby(time.Minute)))
+	defer ts.Close()
+
+	ids := map[string]bool{}
+	for _, pid := range []string{"1", "2"} {
+		resp := request(t, "POST", ts.URL+"/games/join", pid, "")
+		g := sh.Game{}
+		json.NewDecoder(resp.Body).Decode(&g)
+		resp.Body.Close()
+		ids[g.ID] = true
+	}
+	if len(ids) != 1 {
+		t.Fatal("Expected both players to land in the same game", ids)
+	}
+
+	resp := request(t, "GET", ts.URL+"/games", "3", "")
+	open := []sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&open)
+	resp.Body.Close()
+	if len(open) != 1 || len(open[0].Players) != 2 {
+		t.Fatal("Expected one open game with two players", open)
+	}
+}
+
+func TestServerSpectateViews(t *testing.T) {
+	lobby := sh.NewLobby(time.Minute)
+	g, err := lobby.CreateGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(lobby))
+	defer ts.Close()
+	id := g.FilteredGame(context.Background()).ID
+
+	resp := request(t, "GET", ts.URL+"/games/"+id+"/spectate?view=omniscient", "", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected the omniscient view to be refused without a delay", resp.StatusCode)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/games/" + id + "/spectate"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestServerSpectateOmniscient(t *testing.T) {
+	lobby := sh.NewLobby(time.Minute)
+	s := NewServer(lobby)
+	s.OmniscientDelayEvents = 5
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	g, err := lobby.CreateGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := ts.URL + "/games/" + g.FilteredGame(context.Background()).ID + "/spectate?view=omniscient"
+	spectate := func(token string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	//Without accounts a player can't be told apart from anyone else
+	if status := spectate(""); status != http.StatusForbidden {
+		t.Fatal("Expected the omniscient view to be refused without accounts", status)
+	}
+
+	s.Auth = auth.New([]byte("key"), time.Hour)
+	register := func(name string) (string, string) {
+		resp, err := http.Post(ts.URL+"/auth/register", "application/json", strings.NewReader(`{"name":"`+name+`","password":"hunter2"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		session := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&session)
+		return session["token"], session["playerId"]
+	}
+	player, pid := register("sean")
+	watcher, _ := register("ryan")
+	if err := g.SubmitEvent(sh.WithViewer(context.Background(), sh.PlayerViewer(pid)), sh.PlayerEvent{
+		BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+		Player:    sh.Player{ID: pid},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if status := spectate(watcher); status != http.StatusForbidden {
+		t.Fatal("Expected the omniscient view to be refused before the game starts", status)
+	}
+	if err := g.SubmitEvent(sh.WithViewer(context.Background(), sh.AdminViewer()), sh.GameEvent{
+		BaseEvent: sh.BaseEvent{Type: sh.TypeGameUpdate},
+		Game:      sh.Game{State: sh.GameStateStarted},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if status := spectate(""); status != http.StatusUnauthorized {
+		t.Fatal("Expected an anonymous omniscient spectator to be refused", status)
+	}
+	if status := spectate(player); status != http.StatusForbidden {
+		t.Fatal("Expected a player to be refused the omniscient view of their own game", status)
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+watcher)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), header)
+	if err != nil {
+		t.Fatal("Expected someone else to be able to watch", err)
+	}
+	conn.Close()
+}
+
+func TestServerAuth(t *testing.T) {
+	s := NewServer(sh.NewLobby(time.Minute))
+	s.Auth = auth.New([]byte("key"), time.Hour)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	login := func(path string) (string, string) {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(`{"name":"sean","password":"hunter2"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("Expected to be logged in", path, resp.StatusCode)
+		}
+		session := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&session)
+		return session["token"], session["playerId"]
+	}
+	authed := func(method, url, token string) *http.Response {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	token, pid := login("/auth/register")
+	//Claiming to be a player isn't enough once accounts are enabled
+	resp := request(t, "POST", ts.URL+"/games/join", pid, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected an unauthenticated request to be refused", resp.StatusCode)
+	}
+	resp = authed("POST", ts.URL+"/games/join", token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected to join a game", resp.StatusCode)
+	}
+
+	//Logging in again, with the old session gone, resumes the same player
+	resp = authed("POST", ts.URL+"/auth/logout", token)
+	resp.Body.Close()
+	resp = authed("GET", ts.URL+"/games/mine", token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected the revoked token to be refused", resp.StatusCode)
+	}
+	token, _ = login("/auth/login")
+	resp = authed("GET", ts.URL+"/games/mine", token)
+	games := []sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&games)
+	resp.Body.Close()
+	if len(games) != 1 || games[0].Players[0].ID != pid {
+		t.Fatal("Expected to be back in the game", games)
+	}
+}
+
+func TestServerStreamCloses(t *testing.T) {
+	lobby := sh.NewLobby(time.Minute)
+	ts := httptest.NewServer(NewServer(lobby))
+	defer ts.Close()
+	resp := request(t, "POST", ts.URL+"/games", "1", "")
+	g := sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&g)
+	resp.Body.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/games/" + g.ID + "/ws?playerId=1&from=1"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	//A game that is shut down closes the stream cleanly
+	game, err := lobby.GetGame(g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatal("Expected the stream to be closed cleanly", string(msg), err)
+	}
+
+	//Only a subscriber that fell behind is told so
+	game = sh.NewSecretHitler()
+	defer game.Close()
+	game.AddSubscriberWithOptions("slow", make(chan sh.Event), sh.SubscriberOptions{QueueSize: 1})
+	if fellBehind(game, "slow") {
+		t.Fatal("Expected a subscriber that keeps up not to have fallen behind")
+	}
+	for i := 1; i <= 3; i++ {
+		game.BroadcastEvent(sh.BaseEvent{ID: i, Type: sh.TypeGameUpdate})
+	}
+	if !fellBehind(game, "slow") {
+		t.Fatal("Expected the subscriber to have fallen behind")
+	}
+}
+
+func TestServerStreamFinished(t *testing.T) {
+	lobby := sh.NewLobby(time.Minute)
+	ts := httptest.NewServer(NewServer(lobby))
+	defer ts.Close()
+	resp := request(t, "POST", ts.URL+"/games", "1", "")
+	g := sh.Game{}
+	json.NewDecoder(resp.Body).Decode(&g)
+	resp.Body.Close()
+	game, err := lobby.GetGame(g.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = game.SubmitEvent(sh.WithViewer(context.Background(), sh.AdminViewer()), sh.GameEvent{
+		BaseEvent: sh.BaseEvent{Type: sh.TypeGameUpdate},
+		Game:      sh.Game{State: sh.GameStateFinished},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"", "&from=1"} {
+		wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/games/" + g.ID + "/ws?playerId=1" + query
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal("Expected the final game", query, err)
+		}
+		e, err := sh.UnmarshalEvent(msg)
+		if ge, ok := e.(sh.GameEvent); err != nil || !ok || ge.Game.State != sh.GameStateFinished {
+			t.Fatal("Expected the final game", query, string(msg), err)
+		}
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Fatal("Expected the stream to be closed", query, err)
+		}
+		conn.Close()
+	}
+}
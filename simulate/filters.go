@@ -0,0 +1,291 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//FilterError is a value the filters got wrong for a viewer. Either something
+//hidden was shown to them, or something of their own was kept from them.
+type FilterError struct {
+	EventID int
+	Viewer  string
+	Problem string
+}
+
+func (e FilterError) Error() string {
+	return fmt.Sprintf("Event %d filtered for %s: %s", e.EventID, e.Viewer, e.Problem)
+}
+
+//CheckFilters filters every event of a game, and the game after every event,
+//for each player and for the public spectators. Each filtered value is
+//marshalled as it would be sent, and the json is searched for the secrets the
+//viewer must not know at that point in the game: the token secrets of the
+//other players, the roles and parties they haven't learned, the policies that
+//aren't in their hands and the votes still being cast. None of the filter
+//rules are restated; only what each viewer knows.
+func CheckFilters(events []sh.Event) []FilterError {
+	ret := []FilterError{}
+	g := sh.Game{}
+	for _, e := range events {
+		g, _ = g.Replay(e)
+	}
+	viewers := []sh.Viewer{sh.SpectatorViewer()}
+	for _, p := range g.Players {
+		viewers = append(viewers, sh.PlayerViewer(p.ID))
+	}
+
+	g = sh.Game{}
+	//counted is the last round whose votes were announced
+	counted := 0
+	for _, e := range events {
+		var err error
+		if g, err = g.Replay(e); err != nil {
+			return append(ret, FilterError{e.GetID(), "", err.Error()})
+		}
+		tg, err := parse(g)
+		if err != nil {
+			return append(ret, FilterError{e.GetID(), "", err.Error()})
+		}
+		te, err := parse(e)
+		if err != nil {
+			return append(ret, FilterError{e.GetID(), "", err.Error()})
+		}
+		//Claims are the players own to share, true or not, along with the
+		//tokens they present with them
+		claim := false
+		switch te := e.(type) {
+		case sh.AssertEvent, sh.GuessEvent:
+			claim = true
+		case sh.VoteResultEvent:
+			counted = te.RoundID
+		}
+		for _, v := range viewers {
+			k := knowledge{g: g, viewer: v.ID(), counted: counted == g.Round.ID}
+			ctx := sh.WithViewer(context.Background(), v)
+			if fg, err := json.Marshal(g.Filter(ctx)); err != nil {
+				k.fail("Game didn't marshal: %v", err)
+			} else {
+				k.search(tg, fg, false)
+			}
+			fe, err := json.Marshal(e.Filter(ctx))
+			if err != nil {
+				k.fail("Event didn't marshal: %v", err)
+			} else if ue, err := sh.UnmarshalEvent(fe); err != nil || ue.GetType() != e.GetType() || ue.GetID() != e.GetID() {
+				k.fail("Event didn't survive a round trip through json: %v", err)
+			} else {
+				k.search(te, fe, claim)
+			}
+			for _, p := range k.problems {
+				ret = append(ret, FilterError{e.GetID(), v.ID(), p})
+			}
+		}
+	}
+	return ret
+}
+
+//knowledge is what a viewer knows, going by the true game right after an event
+type knowledge struct {
+	g      sh.Game
+	viewer string
+	//counted is set once the votes of the round have been announced
+	counted  bool
+	problems []string
+}
+
+func (k *knowledge) fail(format string, a ...interface{}) {
+	k.problems = append(k.problems, fmt.Sprintf(format, a...))
+}
+
+func (k *knowledge) finished() bool {
+	return k.g.State == sh.GameStateFinished
+}
+
+//knows is whether the rules let the viewer know the role and party of a player
+func (k *knowledge) knows(playerID string) (role bool, party bool) {
+	if k.finished() || playerID == k.viewer {
+		return true, true
+	}
+	me, err := k.g.GetPlayerByID(k.viewer)
+	if err == nil && (me.Role == sh.RoleFascist || (me.Role == sh.RoleHitler && len(k.g.Players) < 7)) {
+		return true, true
+	}
+	p, _ := k.g.GetPlayerByID(playerID)
+	return false, p.InvestigatedBy != "" && p.InvestigatedBy == k.viewer
+}
+
+//holds is the number of policies the viewer may see under a key: the hand of
+//the president or chancellor, and the top of the draw pile for a president
+//that peeked. The discard pile is never seen.
+func (k *knowledge) holds(key string) int {
+	if k.finished() {
+		return -1
+	}
+	peeked := k.viewer == k.g.PreviousPresidentID && k.g.Fascist == 3 && len(k.g.Players) < 7
+	switch {
+	case key == "draw" && peeked:
+		return 3
+	case key != "policies":
+		return 0
+	case k.viewer == k.g.Round.PresidentID || peeked:
+		return 3
+	case k.viewer == k.g.Round.ChancellorID:
+		return 2
+	}
+	return 0
+}
+
+//secrets are the token secrets in the true json that the viewer must not see,
+//and those of their own that they must. The tokens presented with a claim are
+//shown to everyone, and the signing secrets to no one.
+func (k *knowledge) secrets(truth interface{}, claim bool) (hidden, own []string) {
+	walk(truth, "", nil, func(key string, v interface{}, obj map[string]interface{}) {
+		s, _ := v.(string)
+		if s == "" || s == "masked" {
+			return
+		}
+		switch key {
+		case "token":
+			switch owner(obj) {
+			case k.viewer:
+				own = append(own, s)
+			case sh.PlayerIDAll:
+			default:
+				if !claim {
+					hidden = append(hidden, s)
+				}
+			}
+		case "secret":
+			hidden = append(hidden, s)
+		}
+	})
+	return hidden, own
+}
+
+//parse marshals the value and reads the json back generically, so it can be
+//walked
+func parse(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	err = json.Unmarshal(b, &ret)
+	return ret, err
+}
+
+//search looks through the filtered json for anything the viewer must not know,
+//and for anything of their own that was kept from them, going by the true
+//value as parsed
+func (k *knowledge) search(tv interface{}, filtered []byte, claim bool) {
+	var fv interface{}
+	if json.Unmarshal(filtered, &fv) != nil {
+		k.fail("Didn't survive a round trip through json")
+		return
+	}
+	hidden, own := k.secrets(tv, claim)
+	for _, s := range hidden {
+		if bytes.Contains(filtered, []byte(s)) {
+			k.fail("Secret %.8s... shown", s)
+		}
+	}
+	for _, s := range own {
+		if !bytes.Contains(filtered, []byte(s)) {
+			k.fail("Own token withheld")
+		}
+	}
+
+	//A delta resets the round policies with a placeholder, which has to
+	//survive the filter for the viewer to apply the delta
+	if placeholders(tv) != placeholders(fv) {
+		k.fail("Round policies placeholder lost")
+	}
+	shown := map[string]int{}
+	walk(fv, "", nil, func(key string, v interface{}, obj map[string]interface{}) {
+		s, _ := v.(string)
+		switch key {
+		case "seed":
+			if n, _ := v.(float64); n != 0 && !k.finished() {
+				k.fail("Game seed shown")
+			}
+		case "role", "party":
+			if id, _ := obj["id"].(string); id == k.viewer && s == "masked" {
+				k.fail("Own %s withheld", key)
+			}
+			if claim || s == "" || s == "masked" {
+				return
+			}
+			role, party := k.knows(subject(obj))
+			if (key == "role" && !role) || (key == "party" && !party) {
+				k.fail("%s of player %s shown", key, subject(obj))
+			}
+		case "vote":
+			if b, _ := v.(bool); b && owner(obj) != k.viewer && k.g.Round.State == sh.RoundStateVoting && !k.counted {
+				k.fail("Vote of player %s shown during voting", owner(obj))
+			}
+		case "policies", "draw", "discard":
+			if claim || (s != sh.PolicyLiberal && s != sh.PolicyFascist) {
+				return
+			}
+			//A discard of the viewers own is from their hand
+			if _, card := obj["discard"].(string); card && owner(obj) == k.viewer {
+				return
+			}
+			shown[key]++
+		}
+	})
+	for key, n := range shown {
+		if h := k.holds(key); h >= 0 && n > h {
+			k.fail("%d policies shown under %s", n, key)
+		}
+	}
+}
+
+//walk calls visit with every value in the json, along with the key it is under
+//and the object holding it. The items of an array are under the key of the
+//array.
+func walk(v interface{}, key string, obj map[string]interface{}, visit func(key string, v interface{}, obj map[string]interface{})) {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		for k, iv := range tv {
+			walk(iv, k, tv, visit)
+		}
+	case []interface{}:
+		for _, iv := range tv {
+			walk(iv, key, obj, visit)
+		}
+	default:
+		visit(key, v, obj)
+	}
+}
+
+//owner is the player an object is from or for
+func owner(obj map[string]interface{}) string {
+	if id, ok := obj["playerId"].(string); ok {
+		return id
+	}
+	id, _ := obj["id"].(string)
+	return id
+}
+
+//subject is the player an object tells of
+func subject(obj map[string]interface{}) string {
+	if id, ok := obj["otherPlayerId"].(string); ok && id != "" {
+		return id
+	}
+	return owner(obj)
+}
+
+func placeholders(v interface{}) int {
+	n := 0
+	walk(v, "", nil, func(key string, v interface{}, obj map[string]interface{}) {
+		if key == "policies" && v == "-" {
+			n++
+		}
+	})
+	return n
+}
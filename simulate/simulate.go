@@ -0,0 +1,247 @@
+//Package simulate plays complete games in process, one event at a time, by
+//driving Validate, Apply and Engine directly with bots for every player. There
+//are no goroutines or timers involved, so a game is reproducible from its seed,
+//and a panic in the engine is caught and reported with the game that caused it.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/bot"
+)
+
+//MaxEvents stops a game that has gone on far longer than any real game could
+const MaxEvents = 5000
+
+//StrategyFunc returns the strategy for the player at the given seat of a game
+//with the given seed
+type StrategyFunc func(seed int64, seat int) bot.Strategy
+
+//Heuristic seats a heuristic bot in every seat
+func Heuristic(seed int64, seat int) bot.Strategy {
+	return bot.Heuristic{}
+}
+
+//Random seats a random bot in every seat
+func Random(seed int64, seat int) bot.Strategy {
+	return bot.NewRandom(seed*10 + int64(seat))
+}
+
+//Mixed seats heuristic and random bots in alternate seats
+func Mixed(seed int64, seat int) bot.Strategy {
+	if seat%2 == 0 {
+		return Heuristic(seed, seat)
+	}
+	return Random(seed, seat)
+}
+
+//Result is the outcome of a single simulated game
+type Result struct {
+	Seed             int64
+	Players          int
+	WinningParty     string
+	WinningCondition string
+	Rounds           int
+	//ExecutiveActions counts the executive actions granted in the game
+	ExecutiveActions map[string]int
+	//Rejected counts the events that failed validation
+	Rejected int
+	//Err is set if the engine panicked, the game broke an invariant, or the game
+	//stopped before finishing
+	Err error
+}
+
+type submission struct {
+	viewer sh.Viewer
+	e      sh.Event
+}
+
+//Play runs a game between bots from start to finish. It returns the result
+//along with every event that was applied, so a failed game can be examined.
+func Play(seed int64, players int, strategy StrategyFunc) (ret Result, events []sh.Event) {
+	ret.Seed = seed
+	ret.Players = players
+	ret.ExecutiveActions = map[string]int{}
+	g := sh.Game{}
+	var current sh.Event
+	defer func() {
+		if r := recover(); r != nil {
+			id := 0
+			if current != nil {
+				id = current.GetID()
+			}
+			ret.Err = fmt.Errorf("panic after event %d: %v\n%s", id, r, debug.Stack())
+		}
+	}()
+
+	bots := []*bot.Bot{}
+	queue := []submission{{sh.AdminViewer(), sh.GameEvent{
+		BaseEvent: sh.BaseEvent{Type: sh.TypeGameUpdate},
+		Game:      sh.Game{ID: strconv.FormatInt(seed, 10), Seed: seed},
+	}}}
+	for i := 0; i < players; i++ {
+		b := bot.New(strconv.Itoa(i+1), strategy(seed, i))
+		bots = append(bots, b)
+		queue = append(queue, submission{sh.PlayerViewer(b.ID), sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerJoin},
+			Player:    sh.Player{ID: b.ID},
+		}})
+	}
+	for _, b := range bots {
+		queue = append(queue, submission{sh.PlayerViewer(b.ID), sh.PlayerEvent{
+			BaseEvent: sh.BaseEvent{Type: sh.TypePlayerReady},
+			Player:    sh.Player{ID: b.ID},
+		}})
+	}
+
+	for len(queue) > 0 {
+		if g.EventID >= MaxEvents {
+			ret.Err = fmt.Errorf("game still going after %d events", g.EventID)
+			return ret, events
+		}
+		s := queue[0]
+		queue = queue[1:]
+		ctx := sh.WithViewer(context.Background(), s.viewer)
+		if err := g.Validate(ctx, s.e); err != nil {
+			ret.Rejected++
+			continue
+		}
+		ng, ne, err := g.Apply(s.e)
+		if err != nil {
+			ret.Rejected++
+			continue
+		}
+		g = ng
+		current = ne
+		events = append(events, ne)
+		if errs := sh.Invariants(g); len(errs) > 0 {
+			ret.Err = sh.InvariantError{Event: ne, Errors: errs}
+			return ret, events
+		}
+		switch te := ne.(type) {
+		case sh.GameEvent:
+			if a := te.Game.Round.ExecutiveAction; a != "" && a != "-" {
+				ret.ExecutiveActions[a]++
+			}
+		case sh.FinishedEvent:
+			ret.WinningParty = te.WinningParty
+			ret.WinningCondition = te.WinningCondition
+			ret.Rounds = g.Round.ID
+			return ret, events
+		}
+
+		nes, err := g.Engine(ne)
+		if err != nil {
+			ret.Err = fmt.Errorf("engine error after event %d: %v", ne.GetID(), err)
+			return ret, events
+		}
+		for _, e := range nes {
+			queue = append(queue, submission{sh.EngineViewer(), e})
+		}
+		for _, b := range bots {
+			for _, e := range b.Handle(ne) {
+				queue = append(queue, submission{sh.PlayerViewer(b.ID), e})
+			}
+		}
+	}
+	ret.Err = fmt.Errorf("game stalled after event %d in round state %q", g.EventID, g.Round.State)
+	return ret, events
+}
+
+//Stats aggregates the results of every game played with the same number of
+//players
+type Stats struct {
+	Players          int
+	Games            int
+	Wins             map[string]int
+	Conditions       map[string]int
+	ExecutiveActions map[string]int
+	Rounds           int
+	Rejected         int
+	//Failures are the games that panicked, broke an invariant or didn't finish
+	Failures []Result
+}
+
+func newStats(players int) *Stats {
+	return &Stats{
+		Players:          players,
+		Wins:             map[string]int{},
+		Conditions:       map[string]int{},
+		ExecutiveActions: map[string]int{},
+	}
+}
+
+func (s *Stats) add(r Result) {
+	s.Games++
+	if r.Err != nil {
+		s.Failures = append(s.Failures, r)
+		return
+	}
+	s.Wins[r.WinningParty]++
+	s.Conditions[r.WinningCondition]++
+	for a, c := range r.ExecutiveActions {
+		s.ExecutiveActions[a] += c
+	}
+	s.Rounds += r.Rounds
+	s.Rejected += r.Rejected
+}
+
+//finished is the number of games that ran to completion
+func (s *Stats) finished() int {
+	return s.Games - len(s.Failures)
+}
+
+//WinRate is the share of finished games won by the party
+func (s *Stats) WinRate(party string) float64 {
+	if s.finished() == 0 {
+		return 0
+	}
+	return float64(s.Wins[party]) / float64(s.finished())
+}
+
+//ConditionRate is the share of finished games that ended on the condition
+func (s *Stats) ConditionRate(condition string) float64 {
+	if s.finished() == 0 {
+		return 0
+	}
+	return float64(s.Conditions[condition]) / float64(s.finished())
+}
+
+//AverageRounds is the mean number of rounds in a finished game
+func (s *Stats) AverageRounds() float64 {
+	if s.finished() == 0 {
+		return 0
+	}
+	return float64(s.Rounds) / float64(s.finished())
+}
+
+//ActionsPerGame is the mean number of times the executive action was granted
+//in a finished game
+func (s *Stats) ActionsPerGame(action string) float64 {
+	if s.finished() == 0 {
+		return 0
+	}
+	return float64(s.ExecutiveActions[action]) / float64(s.finished())
+}
+
+//Run plays the given number of games for each player count, with the games
+//seeded one after another starting after the given seed. It returns the stats
+//ordered by player count.
+func Run(games int, players []int, seed int64, strategy StrategyFunc) []*Stats {
+	ret := []*Stats{}
+	for _, n := range players {
+		s := newStats(n)
+		for i := 0; i < games; i++ {
+			r, _ := Play(seed+int64(i)+1, n, strategy)
+			s.add(r)
+		}
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Players < ret[j].Players })
+	return ret
+}
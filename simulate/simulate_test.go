@@ -0,0 +1,111 @@
+package simulate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+func TestRun(t *testing.T) {
+	for name, sf := range map[string]StrategyFunc{"heuristic": Heuristic, "random": Random, "mixed": Mixed} {
+		for _, s := range Run(50, []int{5, 6, 7, 8, 9, 10}, 0, sf) {
+			for _, r := range s.Failures {
+				t.Fatal(name, r.Players, r.Seed, r.Err)
+			}
+			if s.Wins[sh.PartyLiberal]+s.Wins[sh.PartyFascist] != s.Games {
+				t.Fatal("Expected every game to have a winner", name, s.Players, s.Wins)
+			}
+			if s.AverageRounds() < 1 {
+				t.Fatal("Expected games to last at least a round", name, s.Players)
+			}
+		}
+	}
+}
+
+func TestPlayIsReproducible(t *testing.T) {
+	r1, e1 := Play(42, 7, Mixed)
+	r2, e2 := Play(42, 7, Mixed)
+	if r1.Err != nil {
+		t.Fatal(r1.Err)
+	}
+	if !reflect.DeepEqual(r1, r2) || len(e1) != len(e2) {
+		t.Fatal("Expected the same seed to play the same game", r1, r2)
+	}
+	for i := range e1 {
+		if e1[i].GetType() != e2[i].GetType() {
+			t.Fatal("Expected the same events", i, e1[i], e2[i])
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	for seed := int64(1); seed <= 30; seed++ {
+		strategy := Random
+		if seed%2 == 0 {
+			strategy = Mixed
+		}
+		r, events := Play(seed, 5+int(seed%6), strategy)
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if errs := CheckFilters(events); len(errs) > 0 {
+			t.Fatal(len(errs), "filter errors, the first being", errs[0])
+		}
+	}
+}
+
+func FuzzFilters(f *testing.F) {
+	f.Add(int64(1), 5)
+	f.Add(int64(2), 7)
+	f.Add(int64(3), 9)
+	f.Fuzz(func(t *testing.T, seed int64, players int) {
+		if players < 5 || players > 10 {
+			t.Skip()
+		}
+		r, events := Play(seed, players, Mixed)
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if errs := CheckFilters(events); len(errs) > 0 {
+			t.Fatal(len(errs), "filter errors, the first being", errs[0])
+		}
+	})
+}
+
+func TestSearchFindsLeaks(t *testing.T) {
+	g := sh.Game{
+		State: sh.GameStateStarted,
+		Draw:  []string{sh.PolicyFascist, sh.PolicyLiberal},
+		Players: []sh.Player{
+			{ID: "1", Role: sh.RoleLiberal, Party: sh.PartyLiberal},
+			{ID: "2", Role: sh.RoleFascist, Party: sh.PartyFascist},
+		},
+	}
+	request := sh.RequestEvent{PlayerID: "2", Token: "secret-token-of-2"}
+	tests := map[string]struct {
+		truth, filtered interface{}
+		leaks           bool
+	}{
+		"role":         {sh.PlayerEvent{Player: g.Players[1]}, sh.PlayerEvent{Player: g.Players[1]}, true},
+		"masked role":  {sh.PlayerEvent{Player: g.Players[1]}, sh.PlayerEvent{Player: sh.Player{ID: "2", Role: sh.RoleMasked, Party: sh.PartyMasked}}, false},
+		"own role":     {sh.PlayerEvent{Player: g.Players[0]}, sh.PlayerEvent{Player: sh.Player{ID: "1", Role: sh.RoleMasked}}, true},
+		"token":        {request, request, true},
+		"copied token": {request, sh.MessageEvent{PlayerID: "2", Message: request.Token}, true},
+		"draw pile":    {g, g, true},
+		"hidden draw":  {g, sh.Game{State: g.State, Draw: []string{sh.PolicyMasked, sh.PolicyMasked}}, false},
+	}
+	for name, tt := range tests {
+		k := knowledge{g: g, viewer: "1"}
+		tv, err := parse(tt.truth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(tt.filtered)
+		k.search(tv, b, false)
+		if leaks := len(k.problems) > 0; leaks != tt.leaks {
+			t.Fatal("Expected the search to find what was shown:", name, k.problems)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package sh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//SnapshotStore keeps the latest copy of the full, unfiltered state of each
+//game, so loading a game only has to replay the events after it.
+type SnapshotStore interface {
+	//SaveSnapshot replaces the stored snapshot for the game
+	SaveSnapshot(g Game) error
+	//LoadSnapshot returns the latest snapshot of the game, or an empty game if
+	//there is none
+	LoadSnapshot(gameID string) (Game, error)
+}
+
+func (sh *SecretHitler) snapshot() error {
+	if sh.SnapshotInterval <= 0 || sh.Game.EventID%sh.SnapshotInterval != 0 {
+		return nil
+	}
+	ss, ok := sh.Store.(SnapshotStore)
+	if !ok {
+		return nil
+	}
+	return ss.SaveSnapshot(sh.Game)
+}
+
+//VerifySnapshot replays the event log of the game from the very first event up
+//to the latest snapshot, and compares the result with the snapshot. This will
+//catch any divergence in Apply that would make a snapshot load differently
+//than a full replay.
+func VerifySnapshot(s EventStore, gameID string) error {
+	ss, ok := s.(SnapshotStore)
+	if !ok {
+		return errors.New("Store does not keep snapshots")
+	}
+	snap, err := ss.LoadSnapshot(gameID)
+	if err != nil {
+		return err
+	}
+	if snap.EventID == 0 {
+		return nil
+	}
+	events, err := s.Load(gameID)
+	if err != nil {
+		return err
+	}
+	g := Game{}
+	for _, e := range events {
+		if e.GetID() > snap.EventID {
+			break
+		}
+		g, err = g.Replay(e)
+		if err != nil {
+			return err
+		}
+	}
+	if g.EventID != snap.EventID {
+		return fmt.Errorf("Event log ends at %d before the snapshot at %d", g.EventID, snap.EventID)
+	}
+	return compareGames(snap, g)
+}
+
+//compareGames reports the fields that differ between the two games
+func compareGames(expected, actual Game) error {
+	eb, err := json.Marshal(expected)
+	if err != nil {
+		return err
+	}
+	ab, err := json.Marshal(actual)
+	if err != nil {
+		return err
+	}
+	em := map[string]json.RawMessage{}
+	am := map[string]json.RawMessage{}
+	json.Unmarshal(eb, &em)
+	json.Unmarshal(ab, &am)
+	diff := []string{}
+	for k, v := range em {
+		if string(am[k]) != string(v) {
+			diff = append(diff, k)
+		}
+	}
+	for k := range am {
+		if _, ok := em[k]; !ok {
+			diff = append(diff, k)
+		}
+	}
+	if len(diff) > 0 {
+		sort.Strings(diff)
+		return fmt.Errorf("Game %s diverged at event %d in: %s", expected.ID, expected.EventID, strings.Join(diff, ", "))
+	}
+	return nil
+}
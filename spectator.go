@@ -0,0 +1,104 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//Spectator is the view a spectator subscribes to the game with. The public view
+//is filtered like the view of someone that isn't playing, only showing what is
+//known to the whole table. The omniscient view shows everything, the roles and
+//the policies in every hand included, so it must be delayed or a spectator
+//could pass it on to the players still in the game.
+type Spectator struct {
+	Omniscient bool
+	//DelayEvents holds each event back until this many newer events have been
+	//broadcast
+	DelayEvents int
+	//DelayTime holds each event back until this long after it happened
+	DelayTime time.Duration
+}
+
+//Viewer is who the events are filtered for
+func (s Spectator) Viewer() Viewer {
+	if s.Omniscient {
+		return OmniscientViewer()
+	}
+	return SpectatorViewer()
+}
+
+//AddSpectator subscribes the channel to the game as a spectator. Unlike
+//AddSubscriber the events are filtered for the spectators view before they are
+//sent, in order, and held back for as long as the delay requires. Once the game
+//is finished there is nothing left to hide, and everything held back is sent.
+//The spectator is removed with RemoveSubscriber.
+func (sh *SecretHitler) AddSpectator(key string, s Spectator, c chan<- Event) error {
+	if s.DelayEvents < 0 || s.DelayTime < 0 {
+		return errors.New("Spectator delay can't be negative")
+	}
+	if s.Omniscient && s.DelayEvents == 0 && s.DelayTime == 0 {
+		return errors.New("An omniscient spectator must be delayed")
+	}
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	if sh.Game.State == GameStateFinished {
+		return errors.New("Game is finished")
+	}
+	sh.subM.Lock()
+	defer sh.subM.Unlock()
+	if sh.subsClosed {
+		return ErrClosed
+	}
+	in := make(chan Event, 10)
+	stop := make(chan struct{})
+	latest := sh.Game.EventID
+	sub := newSubscriber(in, sh.Fanout, latest)
+	sub.owned = true
+	sh.addLocked(key, sub)
+	sh.spectators[key] = stop
+	sh.spawn(func() { s.relay(in, c, stop, latest) })
+	return nil
+}
+
+//relay filters the broadcast events for the spectator and passes them on once
+//they are old enough. It keeps reading broadcasts while it waits on the
+//spectator, so the delay can't fill up its queue. It stops once the spectator
+//is removed or disconnected, and leaves the spectator's channel open.
+func (s Spectator) relay(in <-chan Event, out chan<- Event, stop <-chan struct{}, latest int) {
+	ctx := WithViewer(context.Background(), s.Viewer())
+	held := []Event{}
+	finished := false
+	for {
+		var send chan<- Event
+		var next Event
+		var wait <-chan time.Time
+		if len(held) > 0 {
+			next = held[0]
+			age := time.Since(next.GetMoment())
+			if finished || (latest-next.GetID() >= s.DelayEvents && age >= s.DelayTime) {
+				send = out
+			} else if latest-next.GetID() >= s.DelayEvents {
+				wait = time.After(s.DelayTime - age)
+			}
+		}
+		select {
+		case e, ok := <-in:
+			if !ok {
+				return
+			}
+			if e.GetID() > latest {
+				latest = e.GetID()
+			}
+			if _, ok := e.(FinishedEvent); ok {
+				finished = true
+			}
+			held = append(held, e.Filter(ctx))
+		case send <- next:
+			held = held[1:]
+		case <-wait:
+		case <-stop:
+			return
+		}
+	}
+}
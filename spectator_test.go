@@ -0,0 +1,101 @@
+package sh
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSpectatorFilter(t *testing.T) {
+	g := startedGame()
+	g.Round.State = RoundStateLegislating
+	g.Round.ChancellorID = "2"
+	g.Round.Policies = g.Draw[:3]
+	g.Draw = g.Draw[3:]
+
+	spectator := WithViewer(context.Background(), SpectatorViewer())
+	fg := g.Filter(spectator)
+	for _, p := range fg.Players {
+		if p.Role != RoleMasked || p.Party != PartyMasked {
+			t.Fatal("Expected a spectator not to see the roles", p)
+		}
+	}
+	for _, p := range fg.Round.Policies {
+		if p != PolicyMasked {
+			t.Fatal("Expected a spectator not to see the round policies", fg.Round.Policies)
+		}
+	}
+	//A game delta has no president, which must not match the spectators empty id
+	delta := Game{Round: Round{Policies: g.Round.Policies}}.Filter(spectator)
+	if delta.Round.Policies[0] != PolicyMasked {
+		t.Fatal("Expected a spectator not to see the policies in a delta", delta.Round.Policies)
+	}
+
+	omniscient := WithViewer(context.Background(), OmniscientViewer())
+	g.Seed = 42
+	fg = g.Filter(omniscient)
+	if fg.Players[4].Role != RoleHitler || fg.Round.Policies[0] != PolicyLiberal {
+		t.Fatal("Expected an omniscient spectator to see everything")
+	}
+	if fg.Seed != 0 {
+		t.Fatal("Expected an omniscient spectator not to see the seed of a game in progress")
+	}
+	g.State = GameStateFinished
+	if fg = g.Filter(omniscient); fg.Seed != 42 {
+		t.Fatal("Expected the seed to be shown once the game is over")
+	}
+}
+
+func TestAddSpectatorDelay(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	if err := sh.AddSpectator("streamer", Spectator{Omniscient: true}, make(chan Event)); err == nil {
+		t.Fatal("Expected an omniscient spectator without a delay to be refused")
+	}
+
+	c := make(chan Event, 10)
+	if err := sh.AddSpectator("streamer", Spectator{Omniscient: true, DelayEvents: 2}, c); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.RemoveSubscriber("streamer")
+	for i := 1; i <= 4; i++ {
+		id := strconv.Itoa(i)
+		ctx := WithViewer(context.Background(), PlayerViewer(id))
+		err := sh.SubmitEvent(ctx, PlayerEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerJoin},
+			Player:    Player{ID: id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//Only the events with two newer events after them are sent, in order
+	for i := 1; i <= 2; i++ {
+		select {
+		case e := <-c:
+			if e.GetID() != i {
+				t.Fatal("Expected the events in order", i, e.GetID())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event", i)
+		}
+	}
+	select {
+	case e := <-c:
+		t.Fatal("Expected the latest events to be held back", e.GetID())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSpectatorCantJoin(t *testing.T) {
+	ctx := WithViewer(context.Background(), SpectatorViewer())
+	err := Game{}.Validate(ctx, PlayerEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerJoin},
+		Player:    Player{ID: PlayerIDSpectator},
+	})
+	if err == nil {
+		t.Fatal("Expected a spectator to be refused a seat")
+	}
+}
@@ -0,0 +1,21 @@
+package sh
+
+//EventStore persists the event logs of many games. Implementations live in the
+//store package.
+type EventStore interface {
+	//Append adds an applied event to the end of the games event log
+	Append(gameID string, e Event) error
+	//Load returns every event of the game in order
+	Load(gameID string) ([]Event, error)
+	//LoadFrom returns the events of the game with an id after fromEventID
+	LoadFrom(gameID string, fromEventID int) ([]Event, error)
+	//Games lists the ids of every game in the store
+	Games() ([]string, error)
+}
+
+//Forgetter is a store that holds resources open for each game it appends to,
+//such as a file. Forget releases them once the game won't be appended to
+//again; its events stay stored, and can still be loaded.
+type Forgetter interface {
+	Forget(gameID string) error
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	sh "github.com/murphysean/secrethitler"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketGames     = []byte("games")
+	bucketSnapshots = []byte("snapshots")
+	bucketAccounts  = []byte("accounts")
+)
+
+//BoltOptions configures the durability of a BoltStore
+type BoltOptions struct {
+	//NoSync skips the fsync after every append. This is much faster, but events
+	//may be lost if the machine goes down.
+	NoSync bool
+	//Timeout is how long to wait for the lock on the database file
+	Timeout time.Duration
+	//Cipher, when set, encrypts the events and snapshots at rest
+	Cipher *sh.LogCipher
+}
+
+//BoltStore keeps every game in a single embedded key/value database. Each game
+//gets its own bucket, with events keyed by their big endian event id, so a game
+//can be read from any event id without scanning the whole log.
+type BoltStore struct {
+	db     *bolt.DB
+	cipher *sh.LogCipher
+}
+
+func NewBoltStore(path string, opts BoltOptions) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = opts.NoSync
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketGames, bucketSnapshots, bucketAccounts} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, cipher: opts.Cipher}, nil
+}
+
+func eventKey(id int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return k
+}
+
+func (bs *BoltStore) Append(gameID string, e sh.Event) error {
+	if gameID == "" {
+		return errors.New("Invalid game id")
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if b, err = seal(bs.cipher, b, eventAD(gameID, e.GetID())); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		gb, err := tx.Bucket(bucketGames).CreateBucketIfNotExists([]byte(gameID))
+		if err != nil {
+			return err
+		}
+		return gb.Put(eventKey(e.GetID()), b)
+	})
+}
+
+func (bs *BoltStore) Load(gameID string) ([]sh.Event, error) {
+	return bs.LoadFrom(gameID, 0)
+}
+
+func (bs *BoltStore) LoadFrom(gameID string, fromEventID int) ([]sh.Event, error) {
+	ret := []sh.Event{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		gb := tx.Bucket(bucketGames).Bucket([]byte(gameID))
+		if gb == nil {
+			return nil
+		}
+		c := gb.Cursor()
+		next := fromEventID + 1
+		for k, v := c.Seek(eventKey(next)); k != nil; k, v = c.Next() {
+			id := int(binary.BigEndian.Uint64(k))
+			//An encrypted game can't have events dropped from it either
+			if bs.cipher != nil && id != next {
+				return fmt.Errorf("Event %d is missing", next)
+			}
+			next = id + 1
+			b, err := open(bs.cipher, v, eventAD(gameID, id))
+			if err != nil {
+				return err
+			}
+			e, err := sh.UnmarshalEvent(b)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, e)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (bs *BoltStore) Games() ([]string, error) {
+	ret := []string{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGames).ForEach(func(k, v []byte) error {
+			//Only nested buckets have a nil value
+			if v == nil {
+				ret = append(ret, string(k))
+			}
+			return nil
+		})
+	})
+	return ret, err
+}
+
+//SaveSnapshot replaces the stored snapshot of the game
+func (bs *BoltStore) SaveSnapshot(g sh.Game) error {
+	if g.ID == "" {
+		return errors.New("Invalid game id")
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	if b, err = seal(bs.cipher, b, snapshotAD(g.ID)); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSnapshots).Put([]byte(g.ID), b)
+	})
+}
+
+func (bs *BoltStore) LoadSnapshot(gameID string) (sh.Game, error) {
+	g := sh.Game{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSnapshots).Get([]byte(gameID))
+		if b == nil {
+			return nil
+		}
+		b, err := open(bs.cipher, b, snapshotAD(gameID))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &g)
+	})
+	return g, err
+}
+
+//SaveAccount stores the account under its name
+func (bs *BoltStore) SaveAccount(name string, record []byte) error {
+	if name == "" {
+		return errors.New("Invalid account name")
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAccounts).Put([]byte(name), record)
+	})
+}
+
+func (bs *BoltStore) LoadAccounts() (map[string][]byte, error) {
+	ret := map[string][]byte{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAccounts).ForEach(func(k, v []byte) error {
+			//The values are only valid during the transaction
+			ret[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return ret, err
+}
+
+//Close closes the underlying database
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
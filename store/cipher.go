@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//eventAD binds a sealed event to its game and its place in the game
+func eventAD(gameID string, id int) []byte {
+	return []byte("event " + gameID + " " + strconv.Itoa(id))
+}
+
+//snapshotAD binds a sealed snapshot to its game
+func snapshotAD(gameID string) []byte {
+	return []byte("snapshot " + gameID)
+}
+
+//seal encrypts the record if there is a cipher
+func seal(c *sh.LogCipher, b, ad []byte) ([]byte, error) {
+	if c == nil {
+		return b, nil
+	}
+	return c.Seal(b, ad)
+}
+
+//open decrypts the record if there is a cipher
+func open(c *sh.LogCipher, b, ad []byte) ([]byte, error) {
+	if c == nil {
+		return b, nil
+	}
+	ret, err := c.Open(b, ad)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ad, err)
+	}
+	return ret, nil
+}
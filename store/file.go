@@ -0,0 +1,426 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	sh "github.com/murphysean/secrethitler"
+)
+
+//FileOptions configures the durability of a FileStore
+type FileOptions struct {
+	//Sync will fsync the log file after every appended event
+	Sync bool
+	//Cipher, when set, encrypts the events and snapshots at rest. Each line of
+	//a log is the event id followed by the sealed event.
+	Cipher *sh.LogCipher
+}
+
+//FileStore keeps each game in its own append only file of json lines, named
+//after the game id, in a single directory. Unless it is encrypted this is the
+//same format SubmitEvent writes to SecretHitler.Log.
+//The file of a game is kept open once it is appended to, until the game is
+//forgotten, and the offset of each event is indexed the first time the game
+//is used, so loading from an event reads only the events after it.
+type FileStore struct {
+	Dir string
+	FileOptions
+
+	m    sync.Mutex
+	logs map[string]*gameLog
+}
+
+//gameLog is the open file and index of a game
+type gameLog struct {
+	//f is opened for appending on the first append
+	f *os.File
+	//index holds the offset of every event in the file, in order
+	index []logEntry
+	//size is the end of the last event in the file
+	size int64
+}
+
+type logEntry struct {
+	id     int
+	offset int64
+}
+
+func NewFileStore(dir string, opts FileOptions) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	fs := new(FileStore)
+	fs.Dir = dir
+	fs.FileOptions = opts
+	fs.logs = make(map[string]*gameLog)
+	return fs, nil
+}
+
+func (fs *FileStore) path(gameID string) (string, error) {
+	if gameID == "" || strings.ContainsAny(gameID, `/\.`) {
+		return "", errors.New("Invalid game id")
+	}
+	return filepath.Join(fs.Dir, gameID+".log"), nil
+}
+
+func (fs *FileStore) Append(gameID string, e sh.Event) error {
+	p, err := fs.path(gameID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if fs.Cipher != nil {
+		sealed, err := fs.Cipher.Seal(b, eventAD(gameID, e.GetID()))
+		if err != nil {
+			return err
+		}
+		b = append([]byte(strconv.Itoa(e.GetID())+" "), sealed...)
+	}
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	l, err := fs.log(gameID, p)
+	if err != nil {
+		return err
+	}
+	if l.f == nil {
+		if l.f, err = os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return err
+		}
+	}
+	b = append(b, '\n')
+	if _, err = l.f.Write(b); err != nil {
+		//Part of the line may have been written, so the index is rebuilt
+		fs.forget(gameID)
+		return err
+	}
+	l.index = append(l.index, logEntry{id: e.GetID(), offset: l.size})
+	l.size += int64(len(b))
+	if fs.Sync {
+		return l.f.Sync()
+	}
+	return nil
+}
+
+//log returns the open log of the game, indexing its file the first time. The
+//caller must hold the lock.
+func (fs *FileStore) log(gameID, p string) (*gameLog, error) {
+	if l, ok := fs.logs[gameID]; ok {
+		return l, nil
+	}
+	l := new(gameLog)
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		fs.logs[gameID] = l
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			//A line cut off by a crash is left out
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset := l.size
+		l.size += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		id, err := fs.lineID(line)
+		if err != nil {
+			return nil, err
+		}
+		l.index = append(l.index, logEntry{id: id, offset: offset})
+	}
+	fs.logs[gameID] = l
+	return l, nil
+}
+
+//lineID is the id of the event on a line of a log
+func (fs *FileStore) lineID(line []byte) (int, error) {
+	if fs.Cipher != nil {
+		id, _, err := splitSealed(line)
+		return id, err
+	}
+	e := sh.BaseEvent{}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return 0, err
+	}
+	return e.ID, nil
+}
+
+//splitSealed splits a line of an encrypted log into its event id and the
+//sealed event
+func splitSealed(line []byte) (int, []byte, error) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return 0, nil, errors.New("Event log is not encrypted")
+	}
+	id, err := strconv.Atoi(string(line[:i]))
+	if err != nil {
+		return 0, nil, errors.New("Event log is not encrypted")
+	}
+	return id, line[i+1:], nil
+}
+
+func (fs *FileStore) Load(gameID string) ([]sh.Event, error) {
+	return fs.LoadFrom(gameID, 0)
+}
+
+func (fs *FileStore) LoadFrom(gameID string, fromEventID int) ([]sh.Event, error) {
+	p, err := fs.path(gameID)
+	if err != nil {
+		return nil, err
+	}
+	fs.m.Lock()
+	l, err := fs.log(gameID, p)
+	if err != nil {
+		fs.m.Unlock()
+		return nil, err
+	}
+	i := sort.Search(len(l.index), func(i int) bool { return l.index[i].id > fromEventID })
+	if i == len(l.index) {
+		fs.m.Unlock()
+		return []sh.Event{}, nil
+	}
+	prev := 0
+	if i > 0 {
+		prev = l.index[i-1].id
+	}
+	start, end := l.index[i].offset, l.size
+	fs.m.Unlock()
+
+	//Only the events that were indexed are read, so an append under way is
+	//never read half written
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := io.NewSectionReader(f, start, end-start)
+	if fs.Cipher != nil {
+		return fs.decryptEvents(gameID, r, prev)
+	}
+	return decodeEvents(r, fromEventID)
+}
+
+func (fs *FileStore) Games() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(fs.Dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, p := range paths {
+		ret = append(ret, strings.TrimSuffix(filepath.Base(p), ".log"))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+//SaveSnapshot writes the game to a snapshot file next to its log, replacing any
+//previous snapshot atomically.
+func (fs *FileStore) SaveSnapshot(g sh.Game) error {
+	p, err := fs.path(g.ID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	if b, err = seal(fs.Cipher, b, snapshotAD(g.ID)); err != nil {
+		return err
+	}
+	return fs.replace(strings.TrimSuffix(p, ".log")+".snapshot", b)
+}
+
+//replace writes the file at the path atomically, through a temporary file
+//renamed over it
+func (fs *FileStore) replace(p string, b []byte) error {
+	f, err := os.CreateTemp(fs.Dir, filepath.Base(p)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if fs.Sync {
+		if err = f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (fs *FileStore) LoadSnapshot(gameID string) (sh.Game, error) {
+	g := sh.Game{}
+	p, err := fs.path(gameID)
+	if err != nil {
+		return g, err
+	}
+	b, err := os.ReadFile(strings.TrimSuffix(p, ".log") + ".snapshot")
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return g, err
+	}
+	if b, err = open(fs.Cipher, b, snapshotAD(gameID)); err != nil {
+		return g, err
+	}
+	err = json.Unmarshal(b, &g)
+	return g, err
+}
+
+//accountsPath is the file every account is kept in, by name
+func (fs *FileStore) accountsPath() string {
+	return filepath.Join(fs.Dir, "accounts.json")
+}
+
+//SaveAccount adds the account to the accounts file, replacing it atomically
+func (fs *FileStore) SaveAccount(name string, record []byte) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	accounts, err := fs.loadAccounts()
+	if err != nil {
+		return err
+	}
+	accounts[name] = record
+	b, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+	return fs.replace(fs.accountsPath(), b)
+}
+
+func (fs *FileStore) LoadAccounts() (map[string][]byte, error) {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	return fs.loadAccounts()
+}
+
+func (fs *FileStore) loadAccounts() (map[string][]byte, error) {
+	ret := map[string][]byte{}
+	b, err := os.ReadFile(fs.accountsPath())
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &ret)
+	return ret, err
+}
+
+//Forget closes the file of the game and drops its index
+func (fs *FileStore) Forget(gameID string) error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	return fs.forget(gameID)
+}
+
+func (fs *FileStore) forget(gameID string) error {
+	l, ok := fs.logs[gameID]
+	delete(fs.logs, gameID)
+	if !ok || l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+//Close closes every open log file
+func (fs *FileStore) Close() error {
+	fs.m.Lock()
+	defer fs.m.Unlock()
+	var ret error
+	for id := range fs.logs {
+		if err := fs.forget(id); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func decodeEvents(r io.Reader, fromEventID int) ([]sh.Event, error) {
+	ret := []sh.Event{}
+	d := json.NewDecoder(r)
+	for {
+		var rm json.RawMessage
+		err := d.Decode(&rm)
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return ret, err
+		}
+		e, err := sh.UnmarshalEvent(rm)
+		if err != nil {
+			return ret, err
+		}
+		if e.GetID() > fromEventID {
+			ret = append(ret, e)
+		}
+	}
+}
+
+//decryptEvents reads the events of an encrypted log after the event prev. The
+//ids of the events have to follow one another, so a line that was moved,
+//dropped or repeated is refused along with one that was changed.
+func (fs *FileStore) decryptEvents(gameID string, r io.Reader, prev int) ([]sh.Event, error) {
+	ret := []sh.Event{}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16<<20)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		id, sealed, err := splitSealed(line)
+		if err != nil {
+			return ret, err
+		}
+		if id != prev+1 {
+			return ret, fmt.Errorf("Event %d follows event %d", id, prev)
+		}
+		prev = id
+		b, err := open(fs.Cipher, sealed, eventAD(gameID, id))
+		if err != nil {
+			return ret, err
+		}
+		e, err := sh.UnmarshalEvent(b)
+		if err != nil {
+			return ret, err
+		}
+		if e.GetID() != id {
+			return ret, fmt.Errorf("Event %d is sealed as event %d", e.GetID(), id)
+		}
+		ret = append(ret, e)
+	}
+	return ret, s.Err()
+}
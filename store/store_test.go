@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sh "github.com/murphysean/secrethitler"
+	"github.com/murphysean/secrethitler/auth"
+)
+
+func joinEvent(id int, playerID string) sh.Event {
+	return sh.PlayerEvent{
+		BaseEvent: sh.BaseEvent{ID: id, Type: sh.TypePlayerJoin, Moment: time.Now()},
+		Player:    sh.Player{ID: playerID},
+	}
+}
+
+func testEventStore(t *testing.T, s sh.EventStore) {
+	for i := 1; i <= 5; i++ {
+		if err := s.Append("a", joinEvent(i, "p")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append("b", joinEvent(1, "p")); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 || events[4].GetID() != 5 {
+		t.Fatal("Expected all five events back in order", events)
+	}
+	events, err = s.LoadFrom("a", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].GetID() != 4 {
+		t.Fatal("Expected the events after id 3", events)
+	}
+	events, err = s.Load("missing")
+	if err != nil || len(events) != 0 {
+		t.Fatal("Expected no events for a missing game", events, err)
+	}
+	games, err := s.Games()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 2 || games[0] != "a" || games[1] != "b" {
+		t.Fatal("Expected both games to be listed", games)
+	}
+}
+
+func testAccountStore(t *testing.T, s auth.AccountStore) {
+	for _, name := range []string{"sean", "ann"} {
+		if err := s.SaveAccount(name, []byte(`{"playerId":"`+name+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	accounts, err := s.LoadAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || string(accounts["ann"]) != `{"playerId":"ann"}` {
+		t.Fatal("Expected both accounts back", accounts)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir(), FileOptions{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	testEventStore(t, fs)
+	testAccountStore(t, fs)
+	//The accounts don't show up as games
+	if games, _ := fs.Games(); len(games) != 2 {
+		t.Fatal("Expected only the games to be listed", games)
+	}
+}
+
+func TestFileStoreIndex(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEventStore(t, fs)
+	fs.Close()
+
+	//A new store indexes the files it finds, and keeps indexing what it appends
+	fs, err = NewFileStore(dir, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if events, err := fs.LoadFrom("a", 3); err != nil || len(events) != 2 || events[0].GetID() != 4 {
+		t.Fatal("Expected the events after id 3", events, err)
+	}
+	if err := fs.Append("a", joinEvent(6, "p")); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := fs.LoadFrom("a", 5); err != nil || len(events) != 1 || events[0].GetID() != 6 {
+		t.Fatal("Expected the appended event", events, err)
+	}
+	if events, err := fs.LoadFrom("a", 6); err != nil || len(events) != 0 {
+		t.Fatal("Expected no events after the last", events, err)
+	}
+
+	//Forgetting a game closes its file, and it can still be loaded
+	if err := fs.Forget("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.logs["a"]; ok {
+		t.Fatal("Expected the game to be forgotten")
+	}
+	if events, err := fs.Load("a"); err != nil || len(events) != 6 {
+		t.Fatal("Expected a forgotten game to still load", events, err)
+	}
+	if fs.logs["a"].f != nil {
+		t.Fatal("Expected loading not to open the file for appending")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	bs, err := NewBoltStore(filepath.Join(t.TempDir(), "games.db"), BoltOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	testEventStore(t, bs)
+	testAccountStore(t, bs)
+}
+
+func TestLobbyRestore(t *testing.T) {
+	bs, err := NewBoltStore(filepath.Join(t.TempDir(), "games.db"), BoltOptions{NoSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+
+	l := sh.NewLobby(time.Minute)
+	l.Store = bs
+	ctx := sh.WithViewer(context.Background(), sh.PlayerViewer("1"))
+	g, err := l.QuickJoin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := g.FilteredGame(ctx).ID
+	l.Close()
+
+	l = sh.NewLobby(time.Minute)
+	l.Store = bs
+	defer l.Close()
+	ids, err := l.LoadGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != id {
+		t.Fatal("Expected the game to be restored", ids)
+	}
+	g, err = l.GetGame(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fg := g.FilteredGame(ctx); len(fg.Players) != 1 || fg.EventID != 2 {
+		t.Fatal("Expected the restored game to have the joined player", fg)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir(), FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	l := sh.NewLobby(time.Minute)
+	l.Store = fs
+	l.SnapshotInterval = 2
+	var id string
+	for _, pid := range []string{"1", "2", "3"} {
+		ctx := sh.WithViewer(context.Background(), sh.PlayerViewer(pid))
+		g, err := l.QuickJoin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id = g.FilteredGame(ctx).ID
+	}
+	l.Close()
+
+	snap, err := fs.LoadSnapshot(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.EventID != 4 || len(snap.Players) != 3 {
+		t.Fatal("Expected a snapshot after the fourth event", snap.EventID, snap.Players)
+	}
+	if err = sh.VerifySnapshot(fs, id); err != nil {
+		t.Fatal(err)
+	}
+
+	l = sh.NewLobby(time.Minute)
+	l.Store = fs
+	l.VerifySnapshots = true
+	if _, err := l.LoadGames(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := l.GetGame(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := sh.WithViewer(context.Background(), sh.PlayerViewer("1"))
+	if fg := g.FilteredGame(ctx); len(fg.Players) != 3 || fg.EventID != 4 {
+		t.Fatal("Expected the game to load from the snapshot", fg)
+	}
+	l.Close()
+
+	//A snapshot that disagrees with the log should be caught
+	snap.Players = snap.Players[:2]
+	if err = fs.SaveSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if err = sh.VerifySnapshot(fs, id); err == nil {
+		t.Fatal("Expected the diverged snapshot to fail verification")
+	}
+}
+
+func TestEncryptedStores(t *testing.T) {
+	c, err := sh.NewLogCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir, FileOptions{Cipher: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	bs, err := NewBoltStore(filepath.Join(t.TempDir(), "games.db"), BoltOptions{NoSync: true, Cipher: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	for _, s := range []sh.EventStore{fs, bs} {
+		testEventStore(t, s)
+		ss := s.(sh.SnapshotStore)
+		if err := ss.SaveSnapshot(sh.Game{ID: "a", EventID: 5, Secret: "secret"}); err != nil {
+			t.Fatal(err)
+		}
+		if g, err := ss.LoadSnapshot("a"); err != nil || g.Secret != "secret" {
+			t.Fatal("Expected the snapshot back", g, err)
+		}
+	}
+
+	//Nothing is left in the clear on disk
+	for _, name := range []string{"a.log", "a.snapshot"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), sh.TypePlayerJoin) || strings.Contains(string(b), "secret") {
+			t.Fatal("Expected the file to be encrypted", name, string(b))
+		}
+	}
+	//A line moved in the log, or a log read with another key, is refused
+	p := filepath.Join(dir, "a.log")
+	b, _ := os.ReadFile(p)
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1], lines[2] = lines[2], lines[1]
+	os.WriteFile(p, []byte(strings.Join(lines, "")), 0600)
+	if _, err := fs.Load("a"); err == nil {
+		t.Fatal("Expected a moved event to be refused")
+	}
+	other, _ := sh.NewLogCipher([]byte("fedcba9876543210"))
+	fs.Cipher = other
+	if _, err := fs.Load("b"); err == nil {
+		t.Fatal("Expected the wrong key to be refused")
+	}
+	if _, err := fs.LoadSnapshot("a"); err == nil {
+		t.Fatal("Expected the wrong key to be refused for a snapshot")
+	}
+}
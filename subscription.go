@@ -0,0 +1,80 @@
+package sh
+
+import (
+	"context"
+	"errors"
+)
+
+//Subscription is a stream of the events of a game filtered for a viewer,
+//started with Subscribe
+type Subscription struct {
+	sh   *SecretHitler
+	key  string
+	c    chan Event
+	stop func() bool
+}
+
+//Subscribe streams the events after fromEventID, filtered for the viewer. The
+//events already applied are replayed from the Store first, and then the
+//subscription carries on with the live events, with none missed or sent twice.
+//An admin is sent the ErrorEvents of the game as well.
+//A client that reconnects subscribes from the last event it saw. The
+//subscription ends, and its channel is closed, when the context is done, it is
+//closed, or it falls too far behind. Omniscient spectators have to be delayed,
+//so they are refused and must use AddSpectator.
+func (sh *SecretHitler) Subscribe(ctx context.Context, v Viewer, fromEventID int) (*Subscription, error) {
+	if v.Kind == ViewerOmniscient {
+		return nil, errors.New("An omniscient spectator must be delayed")
+	}
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	if fromEventID < 0 || fromEventID > sh.Game.EventID {
+		return nil, errors.New("Unknown event id")
+	}
+	backlog := []Event{}
+	if fromEventID < sh.Game.EventID {
+		if sh.Store == nil {
+			return nil, errors.New("Game has no store to replay events from")
+		}
+		var err error
+		backlog, err = sh.Store.LoadFrom(sh.Game.ID, fromEventID)
+		if err != nil {
+			return nil, err
+		}
+		if len(backlog) != sh.Game.EventID-fromEventID {
+			return nil, errors.New("Store is missing events")
+		}
+	}
+	vctx := WithViewer(context.Background(), v)
+	s := &Subscription{
+		sh:  sh,
+		key: "subscription:" + genUUIDv4(),
+		c:   make(chan Event),
+	}
+	opts := sh.Fanout
+	opts.Admin = v.Trusted()
+	sub := newSubscriber(s.c, opts, fromEventID)
+	sub.backlog = backlog
+	sub.filter = func(e Event) Event { return e.Filter(vctx) }
+	sub.owned = true
+	sh.add(s.key, sub)
+	s.stop = context.AfterFunc(ctx, func() { sh.RemoveSubscriber(s.key) })
+	return s, nil
+}
+
+//Events is the channel the events are sent on, closed once the subscription
+//ends
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+//Key is the key the subscription is listed under in SubscriberStats
+func (s *Subscription) Key() string {
+	return s.key
+}
+
+//Close ends the subscription
+func (s *Subscription) Close() {
+	s.stop()
+	s.sh.RemoveSubscriber(s.key)
+}
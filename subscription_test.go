@@ -0,0 +1,128 @@
+package sh
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//memStore keeps the events of a single game in memory
+type memStore struct {
+	m      sync.Mutex
+	events []Event
+}
+
+func (s *memStore) Append(gameID string, e Event) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memStore) Load(gameID string) ([]Event, error) {
+	return s.LoadFrom(gameID, 0)
+}
+
+func (s *memStore) LoadFrom(gameID string, fromEventID int) ([]Event, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ret := []Event{}
+	for _, e := range s.events {
+		if e.GetID() > fromEventID {
+			ret = append(ret, e)
+		}
+	}
+	return ret, nil
+}
+
+func (s *memStore) Games() ([]string, error) { return []string{""}, nil }
+
+func join(sh *SecretHitler, id string) error {
+	ctx := WithViewer(context.Background(), PlayerViewer(id))
+	return sh.SubmitEvent(ctx, PlayerEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerJoin},
+		Player:    Player{ID: id},
+	})
+}
+
+func TestSubscribeResumes(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	sh.Store = new(memStore)
+	for i := 1; i <= 3; i++ {
+		if err := join(sh, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sh.Subscribe(context.Background(), PlayerViewer("1"), 4); err == nil {
+		t.Fatal("Expected an event id from the future to be refused")
+	}
+	if _, err := sh.Subscribe(context.Background(), OmniscientViewer(), 0); err == nil {
+		t.Fatal("Expected an omniscient subscription to be refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := sh.Subscribe(ctx, PlayerViewer("1"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Events keep coming while the missed ones are replayed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 4; i <= 8; i++ {
+			if err := join(sh, strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 2; i <= 8; i++ {
+		select {
+		case e := <-s.Events():
+			if e.GetID() != i {
+				t.Fatal("Expected every event once and in order", i, e.GetID())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event", i)
+		}
+	}
+	<-done
+
+	cancel()
+	select {
+	case _, ok := <-s.Events():
+		if ok {
+			t.Fatal("Expected no more events once cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the subscription to end with its context")
+	}
+	s.Close()
+}
+
+func TestSubscribeFilters(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	sh.Store = new(memStore)
+	ctx := WithViewer(context.Background(), AdminViewer())
+	err := sh.SubmitEvent(ctx, InformationEvent{
+		BaseEvent: BaseEvent{Type: TypeGameInformation},
+		PlayerID:  "1",
+		Party:     PartyFascist,
+		Token:     "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sh.Subscribe(context.Background(), PlayerViewer("2"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ie := (<-s.Events()).(InformationEvent)
+	if ie.Party != PartyMasked || ie.Token != "masked" {
+		t.Fatal("Expected the replayed event to be filtered for the viewer", ie)
+	}
+}
@@ -0,0 +1,250 @@
+package sh
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//Timeouts are how long a player has to respond to each kind of request before
+//the engine acts on their behalf. A zero timeout waits forever.
+type Timeouts struct {
+	Acknowledge     time.Duration `json:"acknowledge,omitempty"`
+	Nominate        time.Duration `json:"nominate,omitempty"`
+	Vote            time.Duration `json:"vote,omitempty"`
+	Legislate       time.Duration `json:"legislate,omitempty"`
+	ExecutiveAction time.Duration `json:"executiveAction,omitempty"`
+}
+
+//merge applies the updated timeouts in the same way Apply treats other game
+//fields, a positive value replaces the timeout and -1 clears it
+func (t Timeouts) merge(u Timeouts) Timeouts {
+	m := func(cur, upd time.Duration) time.Duration {
+		if upd > 0 {
+			return upd
+		} else if upd == -1 {
+			return 0
+		}
+		return cur
+	}
+	t.Acknowledge = m(t.Acknowledge, u.Acknowledge)
+	t.Nominate = m(t.Nominate, u.Nominate)
+	t.Vote = m(t.Vote, u.Vote)
+	t.Legislate = m(t.Legislate, u.Legislate)
+	t.ExecutiveAction = m(t.ExecutiveAction, u.ExecutiveAction)
+	return t
+}
+
+func (t Timeouts) forRequest(requestType string) time.Duration {
+	switch requestType {
+	case TypeRequestAcknowledge:
+		return t.Acknowledge
+	case TypeRequestNominate:
+		return t.Nominate
+	case TypeRequestVote:
+		return t.Vote
+	case TypeRequestLegislate:
+		return t.Legislate
+	case TypeRequestExecutiveAction:
+		return t.ExecutiveAction
+	}
+	return 0
+}
+
+//withDeadlines sets the deadline on every request in the events
+func (t Timeouts) withDeadlines(events []Event, now time.Time) []Event {
+	for i, e := range events {
+		re, ok := e.(RequestEvent)
+		if !ok {
+			continue
+		}
+		if d := t.forRequest(re.Type); d > 0 {
+			re.Deadline = now.Add(d)
+			events[i] = re
+		}
+	}
+	return events
+}
+
+//Timeout returns the events the engine will submit once the deadline of the
+//request has passed. This is a timeout event for every player that didn't
+//respond, followed by the default action for them: acknowledging their role, a
+//random eligible chancellor, a nein vote, a random discard or a random
+//executive action target. If the request has already been answered nothing is
+//returned.
+func (g Game) Timeout(r RequestEvent) []Event {
+	ret := []Event{}
+	rng := g.rand()
+	timedOut := func(pid string) {
+		ret = append(ret, TimeoutEvent{
+			BaseEvent:   BaseEvent{Type: TypeGameTimeout},
+			PlayerID:    pid,
+			RoundID:     r.RoundID,
+			RequestType: r.Type,
+		})
+	}
+	//pick chooses a random player the acting player would be allowed to pick
+	pick := func(typ, pid string) []Event {
+		ctx := WithViewer(context.Background(), PlayerViewer(pid))
+		eligible := []Event{}
+		for _, p := range g.Players {
+			e := PlayerPlayerEvent{
+				BaseEvent:     BaseEvent{Type: typ},
+				PlayerID:      pid,
+				OtherPlayerID: p.ID,
+			}
+			if g.Validate(ctx, e) == nil {
+				eligible = append(eligible, e)
+			}
+		}
+		if len(eligible) == 0 {
+			return nil
+		}
+		return []Event{eligible[rng.Intn(len(eligible))]}
+	}
+
+	switch r.Type {
+	case TypeRequestAcknowledge:
+		if g.State != GameStateInit {
+			return nil
+		}
+		for _, p := range g.Players {
+			if !p.Ack {
+				timedOut(p.ID)
+				ret = append(ret, PlayerEvent{
+					BaseEvent: BaseEvent{Type: TypePlayerAcknowledge},
+					Player:    Player{ID: p.ID, Party: p.Party, Role: p.Role},
+				})
+			}
+		}
+	case TypeRequestNominate:
+		if g.Round.ID != r.RoundID || g.Round.State != RoundStateNominating || g.Round.ChancellorID != "" || g.Round.PresidentID != r.PlayerID {
+			return nil
+		}
+		timedOut(r.PlayerID)
+		ret = append(ret, pick(TypePlayerNominate, r.PlayerID)...)
+	case TypeRequestVote:
+		if g.Round.ID != r.RoundID || g.Round.State != RoundStateVoting {
+			return nil
+		}
+		voted := map[string]bool{}
+		for _, v := range g.Round.Votes {
+			voted[v.PlayerID] = true
+		}
+		for _, p := range g.Players {
+			if p.ExecutedBy == "" && !voted[p.ID] {
+				timedOut(p.ID)
+				ret = append(ret, PlayerVoteEvent{
+					BaseEvent: BaseEvent{Type: TypePlayerVote},
+					PlayerID:  p.ID,
+					Vote:      false,
+				})
+			}
+		}
+	case TypeRequestLegislate:
+		if g.Round.ID != r.RoundID || g.Round.State != RoundStateLegislating || len(g.Round.Policies) == 0 {
+			return nil
+		}
+		//Make sure the request is for the step of legislation the round is on
+		actor := g.Round.PresidentID
+		if len(g.Round.Policies) == 2 {
+			actor = g.Round.ChancellorID
+		}
+		if r.PlayerID != actor || r.Veto != (len(g.Round.Policies) == 1) {
+			return nil
+		}
+		timedOut(r.PlayerID)
+		ret = append(ret, PlayerLegislateEvent{
+			BaseEvent: BaseEvent{Type: TypePlayerLegislate},
+			PlayerID:  r.PlayerID,
+			Discard:   g.Round.Policies[rng.Intn(len(g.Round.Policies))],
+		})
+	case TypeRequestExecutiveAction:
+		if g.Round.ID != r.RoundID || g.Round.State != RoundStateExecutiveAction || g.Round.ExecutiveAction != r.ExecutiveAction || g.Round.PresidentID != r.PlayerID {
+			return nil
+		}
+		typ := ""
+		switch r.ExecutiveAction {
+		case ExecutiveActionInvestigate:
+			typ = TypePlayerInvestigate
+		case ExecutiveActionSpecialElection:
+			typ = TypePlayerSpecialElection
+		case ExecutiveActionExecute:
+			typ = TypePlayerExecute
+		default:
+			return nil
+		}
+		timedOut(r.PlayerID)
+		ret = append(ret, pick(typ, r.PlayerID)...)
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+//actingViewer returns the viewer a timeout event is submitted as, the player it
+//is on behalf of or otherwise the engine
+func actingViewer(e Event) Viewer {
+	switch te := e.(type) {
+	case PlayerEvent:
+		return PlayerViewer(te.Player.ID)
+	case PlayerPlayerEvent:
+		return PlayerViewer(te.PlayerID)
+	case PlayerVoteEvent:
+		return PlayerViewer(te.PlayerID)
+	case PlayerLegislateEvent:
+		return PlayerViewer(te.PlayerID)
+	}
+	return EngineViewer()
+}
+
+//armTimeout schedules the request to time out at its deadline
+func (sh *SecretHitler) armTimeout(r RequestEvent) {
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	if sh.timers == nil {
+		sh.timers = make(map[int]*time.Timer)
+	}
+	if _, ok := sh.timers[r.ID]; ok {
+		return
+	}
+	sh.timers[r.ID] = time.AfterFunc(time.Until(r.Deadline), func() {
+		sh.timeout(r)
+	})
+}
+
+func (sh *SecretHitler) timeout(r RequestEvent) {
+	sh.m.Lock()
+	if _, ok := sh.timers[r.ID]; !ok {
+		//The timers were stopped while this one was firing
+		sh.m.Unlock()
+		return
+	}
+	delete(sh.timers, r.ID)
+	//Counted while the timer is still known, so stopTimeouts can wait for it
+	sh.firing.Add(1)
+	defer sh.firing.Done()
+	g := sh.Game
+	events := g.Timeout(r)
+	sh.m.Unlock()
+	for _, e := range events {
+		ctx := WithViewer(sh.ctx, actingViewer(e))
+		if err := sh.SubmitEvent(ctx, e); err != nil && !errors.Is(err, ErrClosed) {
+			sh.reportError(g, r, e, err)
+		}
+	}
+}
+
+//stopTimeouts stops the timers, and waits for any timeout that already fired to
+//finish submitting its events. A timer that fires after this finds itself
+//stopped and does nothing.
+func (sh *SecretHitler) stopTimeouts() {
+	sh.m.Lock()
+	for id, t := range sh.timers {
+		t.Stop()
+		delete(sh.timers, id)
+	}
+	sh.m.Unlock()
+	sh.firing.Wait()
+}
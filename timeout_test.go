@@ -0,0 +1,211 @@
+package sh
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func votingGame() Game {
+	g := Game{
+		Seed:   7,
+		State:  GameStateStarted,
+		Secret: "secret",
+		Round: Round{
+			ID:           3,
+			PresidentID:  "1",
+			ChancellorID: "2",
+			State:        RoundStateVoting,
+			Votes:        []Vote{Vote{PlayerID: "1", Vote: true}, Vote{PlayerID: "2", Vote: true}},
+		},
+	}
+	for i := 1; i <= 5; i++ {
+		g.Players = append(g.Players, Player{ID: strconv.Itoa(i)})
+	}
+	return g
+}
+
+func TestTimeoutVote(t *testing.T) {
+	g := votingGame()
+	events := g.Timeout(RequestEvent{
+		BaseEvent: BaseEvent{Type: TypeRequestVote},
+		PlayerID:  PlayerIDAll,
+		RoundID:   3,
+	})
+	if len(events) != 6 {
+		t.Fatal("Expected a timeout and nein vote for each of the three missing voters", events)
+	}
+	for i, e := range events {
+		if i%2 == 0 {
+			if e.GetType() != TypeGameTimeout {
+				t.Fatal("Expected a timeout event", e)
+			}
+			continue
+		}
+		ve := e.(PlayerVoteEvent)
+		if ve.Vote {
+			t.Fatal("Expected the default vote to be nein")
+		}
+		ctx := WithViewer(context.Background(), PlayerViewer(ve.PlayerID))
+		if err := g.Validate(ctx, ve); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//A request for a round that has moved on should be ignored
+	if events := g.Timeout(RequestEvent{BaseEvent: BaseEvent{Type: TypeRequestVote}, RoundID: 2}); events != nil {
+		t.Fatal("Expected no events for a stale request", events)
+	}
+}
+
+func TestTimeoutNominate(t *testing.T) {
+	g := votingGame()
+	g.Round.State = RoundStateNominating
+	g.Round.ChancellorID = ""
+	g.Round.Votes = nil
+	g.PreviousChancellorID = "3"
+	r := RequestEvent{
+		BaseEvent: BaseEvent{Type: TypeRequestNominate},
+		PlayerID:  "1",
+		RoundID:   3,
+	}
+	events := g.Timeout(r)
+	if len(events) != 2 {
+		t.Fatal("Expected a timeout and a nomination", events)
+	}
+	ne := events[1].(PlayerPlayerEvent)
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	if err := g.Validate(ctx, ne); err != nil {
+		t.Fatal("Expected an eligible chancellor to be picked", err)
+	}
+	g.Round.ChancellorID = ne.OtherPlayerID
+	if events := g.Timeout(r); events != nil {
+		t.Fatal("Expected no events once the president nominated", events)
+	}
+}
+
+func TestTimeoutAcknowledge(t *testing.T) {
+	sh := NewSecretHitler()
+	defer sh.Close()
+	actx := WithViewer(context.Background(), AdminViewer())
+	err := sh.SubmitEvent(actx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{Timeouts: Timeouts{Acknowledge: 10 * time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		ctx := WithViewer(context.Background(), PlayerViewer(strconv.Itoa(i)))
+		for _, typ := range []string{TypePlayerJoin, TypePlayerReady} {
+			err := sh.SubmitEvent(ctx, PlayerEvent{
+				BaseEvent: BaseEvent{Type: typ},
+				Player:    Player{ID: strconv.Itoa(i)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//Nobody acknowledges, so the engine should do it for them and start the first round
+	deadline := time.Now().Add(2 * time.Second)
+	for sh.FilteredGame(actx).Round.State != RoundStateNominating {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the acknowledge timeout to start the game")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//snapStore is a memStore that keeps snapshots as well
+type snapStore struct {
+	memStore
+	snap Game
+}
+
+func (s *snapStore) SaveSnapshot(g Game) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.snap = g
+	return nil
+}
+
+func (s *snapStore) LoadSnapshot(gameID string) (Game, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.snap, nil
+}
+
+func TestTimeoutSurvivesSnapshot(t *testing.T) {
+	s := new(snapStore)
+	sh := NewSecretHitler()
+	sh.Store = s
+	sh.SnapshotInterval = 1
+	actx := WithViewer(context.Background(), AdminViewer())
+	err := sh.SubmitEvent(actx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{Timeouts: Timeouts{Acknowledge: 10 * time.Millisecond, Vote: 300 * time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		ctx := WithViewer(context.Background(), PlayerViewer(strconv.Itoa(i)))
+		for _, typ := range []string{TypePlayerJoin, TypePlayerReady} {
+			err := sh.SubmitEvent(ctx, PlayerEvent{
+				BaseEvent: BaseEvent{Type: typ},
+				Player:    Player{ID: strconv.Itoa(i)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	waitFor := func(sh *SecretHitler, state string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for sh.FilteredGame(actx).Round.State != state {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the round to reach", state)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(sh, RoundStateNominating)
+	g := sh.FilteredGame(actx)
+	pctx := WithViewer(context.Background(), PlayerViewer(g.Round.PresidentID))
+	for _, p := range g.Players {
+		if err = sh.SubmitEvent(pctx, PlayerPlayerEvent{
+			BaseEvent:     BaseEvent{Type: TypePlayerNominate},
+			PlayerID:      g.Round.PresidentID,
+			OtherPlayerID: p.ID,
+		}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(sh, RoundStateVoting)
+	//One vote comes in after the vote request, so the snapshot is taken past it
+	vctx := WithViewer(context.Background(), PlayerViewer("1"))
+	if err = sh.SubmitEvent(vctx, PlayerVoteEvent{BaseEvent: BaseEvent{Type: TypePlayerVote}, PlayerID: "1", Vote: true}); err != nil {
+		t.Fatal(err)
+	}
+	sh.Close()
+	if s.snap.EventID != sh.Game.EventID {
+		t.Fatal("Expected the snapshot to be of the last event", s.snap.EventID, sh.Game.EventID)
+	}
+
+	lsh, err := LoadSecretHitlerFromStore(s, sh.Game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsh.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for lsh.FilteredGame(actx).Round.State == RoundStateVoting {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the vote to time out after the game was reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
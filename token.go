@@ -1,61 +1,241 @@
 package sh
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/hmac"
+	cr "crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"strings"
+	"time"
 )
 
-func createToken(key string, token Token) string {
+//TokenKey is a key a game signs its tokens with. The newest key signs new
+//tokens, the older ones are kept to verify the tokens they signed. A key with a
+//public half is an Ed25519 key, and anyone can verify its tokens with it. A key
+//without one is an HMAC key from before, which only the engine can verify with.
+type TokenKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret,omitempty"`
+	Public string `json:"public,omitempty"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+func newTokenKey() (TokenKey, error) {
+	pub, priv, err := ed25519.GenerateKey(cr.Reader)
+	if err != nil {
+		return TokenKey{}, fmt.Errorf("Token key not generated: %w", err)
+	}
+	return TokenKey{
+		ID:     genUUIDv4(),
+		Secret: base64.RawURLEncoding.EncodeToString(priv.Seed()),
+		Public: base64.RawURLEncoding.EncodeToString(pub),
+	}, nil
+}
+
+//alg is the jwt algorithm the key signs with
+func (k TokenKey) alg() string {
+	if k.Public != "" {
+		return "EdDSA"
+	}
+	return "HS256"
+}
+
+func createToken(key TokenKey, token Token) (string, error) {
+	h, err := json.Marshal(tokenHeader{Alg: key.alg(), Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
 	//First serialize the token into json string
 	b, err := json.Marshal(&token)
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", err
 	}
-	tosign := "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(b)
-	//Generate an hmac on that string
-	sig := hmac.New(sha256.New, []byte(key))
-	_, err = sig.Write([]byte(tosign))
+	tosign := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(b)
+	sig, err := key.sign(tosign)
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", fmt.Errorf("Token not signed with key %s: %w", key.ID, err)
 	}
-	//Base64 the string and the hmac
-	//Return them as [jwtheader].[Base64encodedmessage].[base64encodedhmac]
-	return tosign + "." + base64.RawURLEncoding.EncodeToString(sig.Sum(nil))
+	//Return them as [jwtheader].[Base64encodedmessage].[base64encodedsignature]
+	return tosign + "." + sig, nil
+}
+
+func (k TokenKey) sign(tosign string) (string, error) {
+	if k.Public == "" {
+		if !k.hasSecret() {
+			return "", errors.New("Invalid Key")
+		}
+		return signToken(k.Secret, tosign), nil
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(k.Secret)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return "", errors.New("Invalid Key")
+	}
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(seed), []byte(tosign))
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+//verify checks the signature with the key. The header must name the algorithm
+//of the key, so a public key can't be passed off as an HMAC secret.
+func (k TokenKey) verify(alg, signed, sig string) bool {
+	if alg != k.alg() {
+		return false
+	}
+	if k.Public == "" {
+		if !k.hasSecret() {
+			return false
+		}
+		return hmac.Equal([]byte(sig), []byte(signToken(k.Secret, signed)))
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(k.Public)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), []byte(signed), b)
+}
+
+//hasSecret is whether the key has a secret to sign and verify HMAC tokens with.
+//Anyone can sign a token with an empty secret, or with the placeholder older
+//filters put in its place, so neither proves anything.
+func (k TokenKey) hasSecret() bool {
+	return k.Secret != "" && k.Secret != "masked"
+}
+
+func signToken(secret, tosign string) string {
+	sig := hmac.New(sha256.New, []byte(secret))
+	sig.Write([]byte(tosign))
+	return base64.RawURLEncoding.EncodeToString(sig.Sum(nil))
 }
 
-func validateToken(key, token string) (Token, error) {
+//VerifyToken checks a token against the keys of the game it claims to be from.
+//The key is picked by the kid in the header and the token must be for the
+//audience and not have expired. Ed25519 tokens only need the public keys, which
+//are in every view of the game. HMAC signatures are compared in constant time.
+//Tokens from before keys had ids have no kid, and are checked against the key
+//with an empty id without any audience or expiry.
+func VerifyToken(token string, keys []TokenKey, audience string, now time.Time) (Token, error) {
 	ret := Token{}
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return ret, errors.New("Invalid Token")
 	}
-	if parts[0] != "eyJhbGciOiJIUzI1NiJ9" {
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
 		return ret, errors.New("Invalid Header")
 	}
-	//First decode the message, calc signature
-	sig := hmac.New(sha256.New, []byte(key))
-	_, err := sig.Write([]byte(parts[0] + "." + parts[1]))
-	if err != nil {
-		log.Println(err)
-		return ret, errors.New("Invalid Signature")
+	h := tokenHeader{}
+	if err = json.Unmarshal(hb, &h); err != nil {
+		return ret, errors.New("Invalid Header")
 	}
-	if parts[2] != base64.RawURLEncoding.EncodeToString(sig.Sum(nil)) {
-		return ret, errors.New("Invalid Signature")
+	found := false
+	for _, k := range keys {
+		if k.ID == h.Kid {
+			found = true
+			if !k.verify(h.Alg, parts[0]+"."+parts[1], parts[2]) {
+				return ret, errors.New("Invalid Signature")
+			}
+		}
+	}
+	if !found {
+		return ret, errors.New("Unknown Key")
 	}
 	b, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
 		return ret, err
 	}
-	err = json.Unmarshal(b, &ret)
-	if err != nil {
+	if err = json.Unmarshal(b, &ret); err != nil {
 		return ret, err
 	}
+	if h.Kid == "" {
+		return ret, nil
+	}
+	if ret.Audience != audience {
+		return ret, errors.New("Token is for another game")
+	}
+	if ret.ExpiresAt != 0 && now.Unix() >= ret.ExpiresAt {
+		return ret, errors.New("Token has expired")
+	}
 	return ret, nil
 }
+
+//tokenKeys are the keys the games tokens can be verified with, including the
+//secret of games from before the keys were rotated
+func (g Game) tokenKeys() []TokenKey {
+	if g.Secret == "" {
+		return g.Keys
+	}
+	return append([]TokenKey{{Secret: g.Secret}}, g.Keys...)
+}
+
+//VerifyToken checks that the token was issued by the game. Any view of the game
+//can verify the Ed25519 tokens, but the HMAC tokens of older games can only be
+//verified by the engine, as their secret is filtered from everyone else.
+func (g Game) VerifyToken(token string) (Token, error) {
+	return VerifyToken(token, g.tokenKeys(), g.ID, time.Now())
+}
+
+//token signs the token with the newest key of the game. The engine fails with
+//the error, which the game logs and reports to the admins.
+func (g Game) token(t Token) (string, error) {
+	keys := g.tokenKeys()
+	if len(keys) == 0 {
+		return "", errors.New("Token not signed, the game has no keys")
+	}
+	now := time.Now()
+	t.Audience = g.ID
+	t.IssuedAt = now.Unix()
+	if g.TokenTTL > 0 {
+		t.ExpiresAt = now.Add(g.TokenTTL).Unix()
+	}
+	return createToken(keys[len(keys)-1], t)
+}
+
+//verifyClaim is whether the assertion, in a game with honest claims, claims
+//exactly what its token shows. The order the policies are claimed in doesn't
+//matter.
+func (g Game) verifyClaim(ae AssertEvent, now time.Time) bool {
+	if !g.HonestClaims || ae.Token == "" {
+		return false
+	}
+	t, err := VerifyToken(ae.Token, g.tokenKeys(), g.ID, now)
+	if err != nil || t.PlayerID != ae.PlayerID || t.RoundID != ae.RoundID {
+		return false
+	}
+	switch ae.Type {
+	case TypeAssertPolicies:
+		return t.Assertion == ae.PolicySource && len(t.Policies) > 0 && samePolicies(t.Policies, ae.Policies)
+	case TypeAssertParty:
+		return t.OtherPlayerID == ae.OtherPlayerID && t.Party != "" && t.Party == ae.Party
+	}
+	return false
+}
+
+//RotateTokenKey adds a new key to the game to sign tokens with from now on. The
+//old keys still verify the tokens they signed.
+func (sh *SecretHitler) RotateTokenKey() error {
+	sh.m.RLock()
+	keys := append([]TokenKey{}, sh.Game.Keys...)
+	sh.m.RUnlock()
+	key, err := newTokenKey()
+	if err != nil {
+		return err
+	}
+	ctx := WithViewer(context.Background(), AdminViewer())
+	return sh.SubmitEvent(ctx, GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{Keys: append(keys, key)},
+	})
+}
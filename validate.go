@@ -9,18 +9,22 @@ import (
 //Validate ensures that an event is consistent with the current state and then
 //sends it to the event log.
 func (g Game) Validate(ctx context.Context, e Event) error {
-	pid, _ := ctx.Value("playerID").(string)
+	v := ViewerFrom(ctx)
 	//Must be authenticated
-	if pid == "" {
+	if v.Kind == "" {
 		return errors.New("Player not authenticated")
 	}
+	pid := v.PlayerID
 	//Players must all be ready for game to start
 	switch e.GetType() {
 	case TypePlayerJoin:
 		pje := e.(PlayerEvent)
-		if pje.Player.ID != pid {
+		if !v.Is(pje.Player.ID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
+		if IsReservedID(pje.Player.ID) {
+			return errors.New("PlayerID is reserved")
+		}
 		if g.State != GameStateLobby {
 			return errors.New("Players can only join while the game is in the lobby state")
 		}
@@ -34,7 +38,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerReady:
 		pre := e.(PlayerEvent)
-		if pre.Player.ID != pid {
+		if !v.Is(pre.Player.ID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.State != GameStateLobby {
@@ -44,7 +48,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		for _, p := range g.Players {
 			if p.ID == pre.Player.ID {
 				if p.Ready {
-					errors.New("Player is already ready")
+					return errors.New("Player is already ready")
 				}
 				return nil
 			}
@@ -52,7 +56,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		return errors.New("No player found with matching ID")
 	case TypePlayerAcknowledge:
 		pae := e.(PlayerEvent)
-		if pae.Player.ID != pid {
+		if !v.Is(pae.Player.ID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.State != GameStateInit {
@@ -75,7 +79,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		return errors.New("No player found with matching ID")
 	case TypePlayerNominate:
 		ope := e.(PlayerPlayerEvent)
-		if ope.PlayerID != pid {
+		if !v.Is(ope.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateNominating {
@@ -114,7 +118,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerVote:
 		pve := e.(PlayerVoteEvent)
-		if pve.PlayerID != pid {
+		if !v.Is(pve.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateVoting {
@@ -139,7 +143,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerLegislate:
 		ple := e.(PlayerLegislateEvent)
-		if ple.PlayerID != pid {
+		if !v.Is(ple.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateLegislating {
@@ -173,7 +177,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerInvestigate:
 		ope := e.(PlayerPlayerEvent)
-		if ope.PlayerID != pid {
+		if !v.Is(ope.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateExecutiveAction {
@@ -197,7 +201,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerSpecialElection:
 		ope := e.(PlayerPlayerEvent)
-		if ope.PlayerID != pid {
+		if !v.Is(ope.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateExecutiveAction {
@@ -217,7 +221,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerExecute:
 		ope := e.(PlayerPlayerEvent)
-		if ope.PlayerID != pid {
+		if !v.Is(ope.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State != RoundStateExecutiveAction {
@@ -241,7 +245,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypePlayerMessage:
 		me := e.(MessageEvent)
-		if me.PlayerID != pid {
+		if !v.Is(me.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if p, _ := g.GetPlayerByID(pid); time.Now().Sub(p.LastAction) < time.Second {
@@ -255,7 +259,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		fallthrough
 	case TypeReactStatus:
 		re := e.(ReactEvent)
-		if re.PlayerID != pid {
+		if !v.Is(re.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if p, _ := g.GetPlayerByID(pid); re.Moment.Sub(p.LastAction) < time.Second {
@@ -263,7 +267,7 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypeGuess:
 		ge := e.(GuessEvent)
-		if ge.PlayerID != pid {
+		if !v.Is(ge.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if p, _ := g.GetPlayerByID(pid); ge.Moment.Sub(p.LastAction) < time.Second {
@@ -271,14 +275,18 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypeAssertPolicies:
 		ae := e.(AssertEvent)
-		if ae.PlayerID != pid {
+		if !v.Is(ae.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
 		if g.Round.State == RoundStateLegislating && ae.PolicySource == TypeRequestLegislate {
 			return errors.New("Can't reveal information during legislation")
 		}
+		//With honest claims a claim without a token is free talk
+		if g.HonestClaims && ae.Token == "" {
+			break
+		}
 		//Token must validate
-		t, err := validateToken(g.Secret, ae.Token)
+		t, err := g.VerifyToken(ae.Token)
 		if err != nil {
 			return err
 		}
@@ -296,11 +304,14 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		}
 	case TypeAssertParty:
 		ae := e.(AssertEvent)
-		if ae.PlayerID != pid {
+		if !v.Is(ae.PlayerID) {
 			return errors.New("PlayerID must match currently authenticated user")
 		}
+		if g.HonestClaims && ae.Token == "" {
+			break
+		}
 		//Token must validate
-		t, err := validateToken(g.Secret, ae.Token)
+		t, err := g.VerifyToken(ae.Token)
 		if err != nil {
 			return err
 		}
@@ -313,8 +324,10 @@ func (g Game) Validate(ctx context.Context, e Event) error {
 		if t.OtherPlayerID != ae.OtherPlayerID {
 			return errors.New("OtherPlayerID must match token")
 		}
+	case TypeGameError:
+		return errors.New("Errors are only reported by the game")
 	default:
-		if pid != "admin" && pid != "engine" {
+		if !v.Trusted() {
 			return errors.New("Not Authorized")
 		}
 	}
@@ -6,8 +6,24 @@ import (
 	"time"
 )
 
-func TestValidatePlayerJoin(t *testing.T)            {}
-func TestValidatePlayerReady(t *testing.T)           {}
+func TestValidatePlayerJoin(t *testing.T) {}
+
+func TestValidatePlayerReady(t *testing.T) {
+	g := Game{Players: []Player{Player{ID: "id"}}}
+	e := PlayerEvent{
+		BaseEvent: BaseEvent{Type: TypePlayerReady},
+		Player:    Player{ID: "id", Ready: true},
+	}
+	ctx := WithViewer(context.Background(), PlayerViewer("id"))
+	if err := g.Validate(ctx, e); err != nil {
+		t.Fatal("Expected the player to be able to ready up", err)
+	}
+	g.Players[0].Ready = true
+	if err := g.Validate(ctx, e); err == nil {
+		t.Fatal("Expected a player that is already ready to be refused")
+	}
+}
+
 func TestValidatePlayerAcknowledge(t *testing.T)     {}
 func TestValidatePlayerNominate(t *testing.T)        {}
 func TestValidatePlayerVote(t *testing.T)            {}
@@ -31,7 +47,7 @@ func TestValidateReact(t *testing.T) {
 		PlayerID: "id",
 	}
 	ctx := context.Background()
-	ctx = context.WithValue(ctx, "playerID", "id")
+	ctx = WithViewer(ctx, PlayerViewer("id"))
 	err := g.Validate(ctx, e)
 	if err != nil {
 		t.Fatal("No last reaction should allow reaction", err)
@@ -45,6 +61,86 @@ func TestValidateReact(t *testing.T) {
 
 }
 
-func TestValidateAssertPolicies(t *testing.T) {}
-func TestValidateAssertParty(t *testing.T)    {}
+func TestValidateAssertPolicies(t *testing.T) {
+	g := startedGame()
+	g.Secret = ""
+	g.Keys = []TokenKey{testTokenKey(t)}
+	token := testGameToken(t, g, Token{PlayerID: "1", RoundID: 1, Assertion: TypeRequestLegislate, PolicyCount: 3, Policies: []string{PolicyFascist, PolicyLiberal, PolicyFascist}})
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	claim := func(token string, policies ...string) AssertEvent {
+		return AssertEvent{
+			BaseEvent:    BaseEvent{Type: TypeAssertPolicies},
+			PlayerID:     "1",
+			RoundID:      1,
+			Token:        token,
+			PolicySource: TypeRequestLegislate,
+			Policies:     policies,
+			Verified:     true,
+		}
+	}
+
+	//Without honest claims a token is required, but what is claimed isn't checked
+	if err := g.Validate(ctx, claim("", PolicyFascist, PolicyFascist, PolicyFascist)); err == nil {
+		t.Fatal("Expected a claim without a token to be refused")
+	}
+	ae := claim(token, PolicyFascist, PolicyFascist, PolicyFascist)
+	if err := g.Validate(ctx, ae); err != nil {
+		t.Fatal(err)
+	}
+	if _, ne, _ := g.Apply(ae); ne.(AssertEvent).Verified {
+		t.Fatal("Expected a claim to be unverified without honest claims")
+	}
+
+	g.HonestClaims = true
+	tests := []struct {
+		name     string
+		ae       AssertEvent
+		verified bool
+	}{
+		{"true claim", claim(token, PolicyFascist, PolicyFascist, PolicyLiberal), true},
+		{"lie", claim(token, PolicyFascist, PolicyFascist, PolicyFascist), false},
+		{"free talk", claim("", PolicyLiberal, PolicyLiberal, PolicyLiberal), false},
+	}
+	for _, tt := range tests {
+		if err := g.Validate(ctx, tt.ae); err != nil {
+			t.Fatal(tt.name, err)
+		}
+		_, ne, err := g.Apply(tt.ae)
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+		if ne.(AssertEvent).Verified != tt.verified {
+			t.Fatal("Expected the claim to be verified", tt.name, tt.verified)
+		}
+	}
+	if err := g.Validate(ctx, claim("forged", PolicyFascist, PolicyFascist, PolicyLiberal)); err == nil {
+		t.Fatal("Expected an invalid token to be refused")
+	}
+}
+
+func TestValidateAssertParty(t *testing.T) {
+	g := startedGame()
+	g.Secret = ""
+	g.Keys = []TokenKey{testTokenKey(t)}
+	g.HonestClaims = true
+	token := testGameToken(t, g, Token{PlayerID: "1", RoundID: 1, Assertion: ExecutiveActionInvestigate, OtherPlayerID: "5", Party: PartyFascist})
+	ctx := WithViewer(context.Background(), PlayerViewer("1"))
+	for party, verified := range map[string]bool{PartyFascist: true, PartyLiberal: false} {
+		ae := AssertEvent{
+			BaseEvent:     BaseEvent{Type: TypeAssertParty},
+			PlayerID:      "1",
+			RoundID:       1,
+			Token:         token,
+			OtherPlayerID: "5",
+			Party:         party,
+		}
+		if err := g.Validate(ctx, ae); err != nil {
+			t.Fatal(err)
+		}
+		if _, ne, _ := g.Apply(ae); ne.(AssertEvent).Verified != verified {
+			t.Fatal("Expected the party claim to be verified", party, verified)
+		}
+	}
+}
+
 func TestValidateOther(t *testing.T)          {}
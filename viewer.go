@@ -0,0 +1,95 @@
+package sh
+
+import (
+	"context"
+)
+
+//ViewerKind is who is submitting an event or being shown the game
+type ViewerKind string
+
+const (
+	ViewerPlayer     ViewerKind = "player"
+	ViewerSpectator  ViewerKind = "spectator"
+	ViewerOmniscient ViewerKind = "omniscient"
+	ViewerAdmin      ViewerKind = "admin"
+	ViewerEngine     ViewerKind = "engine"
+)
+
+//Viewer is attached to the context of every call into a game, and decides both
+//what is validated and what is filtered. Only code holding a Viewer can attach
+//one, so a client can't claim to be the engine or the admin by picking that
+//name as its player id, it would only ever be a player by that name.
+type Viewer struct {
+	Kind ViewerKind `json:"kind"`
+	//PlayerID is only set for players
+	PlayerID string `json:"playerId,omitempty"`
+}
+
+func PlayerViewer(playerID string) Viewer {
+	return Viewer{Kind: ViewerPlayer, PlayerID: playerID}
+}
+
+func SpectatorViewer() Viewer {
+	return Viewer{Kind: ViewerSpectator}
+}
+
+func OmniscientViewer() Viewer {
+	return Viewer{Kind: ViewerOmniscient}
+}
+
+func AdminViewer() Viewer {
+	return Viewer{Kind: ViewerAdmin}
+}
+
+func EngineViewer() Viewer {
+	return Viewer{Kind: ViewerEngine}
+}
+
+type viewerKey struct{}
+
+//WithViewer returns a copy of the context carrying the viewer
+func WithViewer(ctx context.Context, v Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, v)
+}
+
+//ViewerFrom returns the viewer carried by the context. Without one the zero
+//Viewer is returned, which can't submit anything and is shown the public view.
+func ViewerFrom(ctx context.Context) Viewer {
+	v, _ := ctx.Value(viewerKey{}).(Viewer)
+	return v
+}
+
+//ID is the player id of a player, or the reserved id standing for any other
+//kind of viewer
+func (v Viewer) ID() string {
+	switch v.Kind {
+	case ViewerPlayer:
+		return v.PlayerID
+	case ViewerSpectator:
+		return PlayerIDSpectator
+	case ViewerOmniscient:
+		return PlayerIDOmniscient
+	case ViewerAdmin:
+		return PlayerIDAdmin
+	case ViewerEngine:
+		return PlayerIDEngine
+	}
+	return ""
+}
+
+//Is reports whether the viewer is the player with the id
+func (v Viewer) Is(playerID string) bool {
+	return v.Kind == ViewerPlayer && playerID != "" && v.PlayerID == playerID
+}
+
+//SeesAll is true for the viewers that are shown the whole game: the admin, the
+//engine and omniscient spectators
+func (v Viewer) SeesAll() bool {
+	return v.Kind == ViewerAdmin || v.Kind == ViewerEngine || v.Kind == ViewerOmniscient
+}
+
+//Trusted is true for the viewers that can submit events on behalf of the game
+//rather than a player
+func (v Viewer) Trusted() bool {
+	return v.Kind == ViewerAdmin || v.Kind == ViewerEngine
+}
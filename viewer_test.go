@@ -0,0 +1,36 @@
+package sh
+
+import (
+	"context"
+	"testing"
+)
+
+func TestViewerCantBeClaimed(t *testing.T) {
+	e := GameEvent{
+		BaseEvent: BaseEvent{Type: TypeGameUpdate},
+		Game:      Game{State: GameStateFinished},
+	}
+	//A player that names itself after the engine is still only a player
+	for _, id := range []string{PlayerIDEngine, PlayerIDAdmin} {
+		ctx := WithViewer(context.Background(), PlayerViewer(id))
+		if err := (Game{}).Validate(ctx, e); err == nil {
+			t.Fatal("Expected a player named", id, "to be refused")
+		}
+	}
+	//So is a raw string key in the context
+	ctx := context.WithValue(context.Background(), "playerID", PlayerIDEngine)
+	if err := (Game{}).Validate(ctx, e); err == nil {
+		t.Fatal("Expected a context without a viewer to be refused")
+	}
+	ctx = WithViewer(context.Background(), EngineViewer())
+	if err := (Game{}).Validate(ctx, e); err != nil {
+		t.Fatal("Expected the engine to be allowed", err)
+	}
+
+	if PlayerViewer(PlayerIDEngine).SeesAll() || !OmniscientViewer().SeesAll() {
+		t.Fatal("Expected only the trusted viewers to see everything")
+	}
+	if SpectatorViewer().Is("") || (Viewer{}).ID() != "" || SpectatorViewer().ID() != PlayerIDSpectator {
+		t.Fatal("Expected a spectator not to be a player")
+	}
+}